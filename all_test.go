@@ -7,7 +7,21 @@
 package gotrees
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"golang.org/x/exp/slices"
 )
@@ -140,9 +154,52 @@ func Test_Size(t *testing.T) {
 // Testing LCA
 func Test_LCA(t *testing.T) {
 	expect := &teamleader1
-	if gotlca := boss.LCA(&developer1, &developer2); gotlca != expect {
+	if gotlca, ok := boss.LCA(&developer1, &developer2); !ok || gotlca != expect {
 		t.Errorf("Expected %v", expect)
 	}
+
+	outside := &Node[Person]{}
+	if gotlca, ok := boss.LCA(&developer1, outside); ok || gotlca != nil {
+		t.Errorf("Expected not found, got %v", gotlca)
+	}
+	if gotlca, ok := boss.LCA(outside, &developer1); ok || gotlca != nil {
+		t.Errorf("Expected not found, got %v", gotlca)
+	}
+	if path := boss.PathN2N(&developer1, outside); len(path) != 0 {
+		t.Errorf("Expected empty path, got %v", path)
+	}
+}
+
+func Test_LCAOf(t *testing.T) {
+	root := newOrgChart()
+	tests := []struct {
+		ids    []string
+		expect string
+	}{
+		{[]string{"5", "6", "44"}, "2"},
+		{[]string{"6", "3"}, "0"},
+		{[]string{"6", "4"}, "4"},
+		{[]string{"6"}, "6"},
+	}
+	for _, tt := range tests {
+		nodes := make([]*Node[Person], len(tt.ids))
+		for i, id := range tt.ids {
+			nodes[i] = root.FindId(id)
+		}
+		if got, ok := root.LCAOf(nodes...); !ok || got.Id != tt.expect {
+			t.Errorf("LCAOf(%v): expected %s, got %v", tt.ids, tt.expect, got)
+		}
+	}
+
+	if _, ok := root.LCAOf(root.FindId("5"), &Node[Person]{}); ok {
+		t.Error("Expected not found for node outside the tree")
+	}
+	if _, ok := root.LCAOf(&Node[Person]{}, root.FindId("5")); ok {
+		t.Error("Expected not found for node outside the tree")
+	}
+	if _, ok := root.LCAOf(); ok {
+		t.Error("Expected not found for no nodes")
+	}
 }
 
 // Testing serialization to JSON
@@ -258,6 +315,22 @@ func Test_Delete(t *testing.T) {
 	}
 }
 
+// Testing deleting children while ranging over them
+func Test_Delete_Range(t *testing.T) {
+	root := newOrgChart()
+	parent := root.FindId("2")
+	children := parent.Children
+	for _, c := range parent.Children {
+		root.Delete(c)
+	}
+	if len(parent.Children) != 0 {
+		t.Errorf("Expected all children deleted, got %s", nodeIds(parent.Children))
+	}
+	if nodeIds(children) != "5,4,44" {
+		t.Errorf("Expected old children slice untouched, got %s", nodeIds(children))
+	}
+}
+
 // Testing deletion for a node inside a tree
 func Test_Delete_Self(t *testing.T) {
 	err := boss.Delete(&boss)
@@ -277,3 +350,2014 @@ func Test_AddNode_WithData(t *testing.T) {
 }
 
 // Testing adding new node without data
+
+// Testing resolving inherited values
+// Boss is inherited from the nearest ancestor when the child leaves it empty
+func Test_Resolve(t *testing.T) {
+	root := newOrgChart()
+	root.Data.Boss = "Board"
+	root.FindId("2").Data.Boss = ""
+
+	merge := func(p, c Person) Person {
+		if c.Boss == "" {
+			c.Boss = p.Boss
+		}
+		return c
+	}
+
+	resolved := Resolve[Person](root, merge)
+	if got := resolved.FindId("2").Data.Boss; got != "Board" {
+		t.Errorf("Expected Board, got %v", got)
+	}
+	if got := resolved.FindId("6").Data.Boss; got != "Amr" {
+		t.Errorf("Expected Amr, got %v", got)
+	}
+	if root.FindId("2").Data.Boss != "" {
+		t.Error("Expected source tree to be unchanged")
+	}
+	if resolved.Size() != root.Size() {
+		t.Errorf("Expected size %v", root.Size())
+	}
+}
+
+// Testing effective value of a single node
+func Test_EffectiveValue(t *testing.T) {
+	root := newOrgChart()
+	root.Data.Boss = "Board"
+	root.FindId("2").Data.Boss = ""
+
+	merge := func(p, c Person) Person {
+		if c.Boss == "" {
+			c.Boss = p.Boss
+		}
+		return c
+	}
+
+	got, err := root.EffectiveValue(root.FindId("2"), merge)
+	if err != nil || got.Boss != "Board" {
+		t.Errorf("Expected Board, got %v", got.Boss)
+	}
+
+	if _, err := root.EffectiveValue(&Node[Person]{}, merge); err != ErrNodeNotFound {
+		t.Errorf("Expected %v", ErrNodeNotFound)
+	}
+}
+
+// Testing recursive sort of children
+func Test_SortChildren(t *testing.T) {
+	root := newOrgChart()
+	byAge := func(a, b *Node[Person]) int {
+		return a.Data.Age - b.Data.Age
+	}
+
+	root.SortChildren(byAge, true)
+	mezo := root.FindId("2")
+	if root.Children[0].Id != "1" {
+		t.Errorf("Expected Hager first, got %v", root.Children[0].Data.Name)
+	}
+
+	expect := []string{"4", "5", "44"}
+	for i, child := range mezo.Children {
+		if child.Id != expect[i] {
+			t.Errorf("Expected Id %v at index %v, got %v", expect[i], i, child.Id)
+		}
+	}
+}
+
+// Testing sorted mode, children are inserted in sorted position
+func Test_SortedTree(t *testing.T) {
+	byName := func(a, b *Node[Person]) int {
+		switch {
+		case a.Data.Name < b.Data.Name:
+			return -1
+		case a.Data.Name > b.Data.Name:
+			return 1
+		}
+		return 0
+	}
+
+	root := SortedTree[Person](byName)
+	c := root.AddNode(Person{Name: "C"})
+	a := root.AddNode(Person{Name: "A"})
+	b := root.AddNode(Person{Name: "B"})
+	b2 := root.AddNode(Person{Name: "B"})
+	a2 := a.AddNode(Person{Name: "Z"})
+	a1 := a.AddNode(Person{Name: "Y"})
+
+	expect := []*Node[Person]{root, a, a1, a2, b, b2, c}
+	got := []*Node[Person]{root}
+	got = append(got, root.Children[0], root.Children[0].Children[0], root.Children[0].Children[1])
+	got = append(got, root.Children[1:]...)
+	for i, n := range expect {
+		if got[i] != n {
+			t.Errorf("Expected memory address %v at index %v", n, i)
+		}
+	}
+
+	// reordering a kept sorted node would break its order
+	a.Id, a1.Id, a2.Id = "a", "a1", "a2"
+	if err := Apply(root, []Op[Person]{{Kind: OpReorder, Parent: "a", Order: []string{"a2", "a1"}}}); err == nil {
+		t.Error("Expected error reordering kept sorted node")
+	}
+
+	// a failed transaction restores sorted mode along with the order
+	st := NewSyncTree(a)
+	st.Update(func(a *Node[Person]) error {
+		a.KeepSorted(func(x, y *Node[Person]) int { return -byName(x, y) })
+		return errors.New("failed")
+	})
+	if x := a.AddNode(Person{Name: "X"}); a.Children[0] != x || a.Children[1] != a1 || a.Children[2] != a2 {
+		t.Errorf("Expected X, Y, Z, got %s", nodeIds(a.Children))
+	}
+
+	// turning sorted mode off appends again
+	root.KeepSorted(nil)
+	if d := root.AddNode(Person{Name: "0"}); root.Children[len(root.Children)-1] != d {
+		t.Errorf("Expected memory address %v at the end", d)
+	}
+}
+
+// Testing structural equality, ordered and unordered
+func Test_Equal(t *testing.T) {
+	eq := func(x, y Person) bool {
+		return x.Name == y.Name
+	}
+
+	a, b := newOrgChart(), newOrgChart()
+	if ok, m := Equal(a, b, eq, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected equal trees, got %v", m)
+	}
+
+	// swap children of Mezo
+	mezo := b.FindId("2")
+	mezo.Children[0], mezo.Children[2] = mezo.Children[2], mezo.Children[0]
+
+	ok, m := Equal(a, b, eq, EqualOptions{})
+	if ok {
+		t.Fatal("Expected ordered comparison to fail")
+	}
+	if m.A != a.FindId("5") || m.String() != "0/2/5: data differs" {
+		t.Errorf("Expected mismatch at 0/2/5, got %v", m)
+	}
+
+	if ok, m := Equal(a, b, eq, EqualOptions{IgnoreOrder: true}); !ok {
+		t.Errorf("Expected unordered comparison to succeed, got %v", m)
+	}
+
+	b.FindId("6").Data.Name = "Foo"
+	ok, m = Equal(a, b, eq, EqualOptions{IgnoreOrder: true})
+	if ok || m.A != a.FindId("6") {
+		t.Errorf("Expected mismatch at Adham, got %v", m)
+	}
+}
+
+// Testing subtree search
+func Test_FindSubtree(t *testing.T) {
+	root := newOrgChart()
+	eq := func(x, y Person) bool {
+		return x.Name == y.Name
+	}
+
+	pattern := &Node[Person]{Data: Person{Name: "Amr"}}
+	pattern.AddNode(Person{Name: "Adham"})
+
+	got := root.FindSubtree(pattern, eq, EqualOptions{})
+	if len(got) != 1 || got[0] != root.FindId("4") {
+		t.Errorf("Expected memory address %v", root.FindId("4"))
+	}
+	if !root.Contains(pattern, eq, EqualOptions{}) {
+		t.Error("Expected tree to contain pattern")
+	}
+	if root.Contains(nil, eq, EqualOptions{}) || len(root.FindSubtree(nil, eq, EqualOptions{})) != 0 {
+		t.Error("Expected nil pattern not found")
+	}
+
+	// structure only, every leaf matches a single node pattern
+	if got := root.FindSubtree(&Node[Person]{}, nil, EqualOptions{}); len(got) != 4 {
+		t.Errorf("Expected 4 leaves, got %v", len(got))
+	}
+}
+
+// Testing diff between two versions of a tree
+func Test_Diff(t *testing.T) {
+	old, new := newOrgChart(), newOrgChart()
+	eq := func(x, y Person) bool {
+		return x == y
+	}
+
+	// move Amr with Adham under Hager, then add a node under Amr
+	amr := new.FindId("4")
+	new.Delete(amr)
+	new.FindId("1").Children = append(new.FindId("1").Children, amr)
+	amr.AddNode(Person{Name: "Foo"}).Id = "7"
+	// delete Jebril, rename Doaa, swap Hany's children
+	new.Delete(new.FindId("44"))
+	new.FindId("3").Data.Name = "Doaa M."
+	new.Children[0], new.Children[1] = new.Children[1], new.Children[0]
+
+	ops, err := Diff(old, new, eq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"move 4 from 2 at 1 to 1 at 1",
+		"insert 7 under 4 at 1: {Foo 0 }",
+		"delete 44 from 2 at 1",
+		"update 3: {Doaa 37 Hager} -> {Doaa M. 37 Hager}",
+		"reorder 0: 1,2",
+	}
+	if len(ops) != len(expect) {
+		t.Fatalf("Expected %v operations, got %v", len(expect), ops)
+	}
+	for i, op := range ops {
+		if op.String() != expect[i] {
+			t.Errorf("Expected %q, got %q", expect[i], op.String())
+		}
+	}
+
+	if ops, _ := Diff(old, newOrgChart(), eq); len(ops) != 0 {
+		t.Errorf("Expected no operations, got %v", ops)
+	}
+	dup := newOrgChart()
+	dup.FindId("6").Id = "5"
+	if _, err := Diff(old, dup, eq); !errors.Is(err, ErrDuplicateId) {
+		t.Errorf("Expected %v", ErrDuplicateId)
+	}
+}
+
+// Testing applying a diff turns old tree into the new one
+func Test_Apply(t *testing.T) {
+	old, new := newOrgChart(), newOrgChart()
+	eq := func(x, y Person) bool {
+		return x == y
+	}
+
+	amr := new.FindId("4")
+	new.Delete(amr)
+	new.FindId("1").Children = append(new.FindId("1").Children, amr)
+	amr.AddNode(Person{Name: "Foo"}).Id = "7"
+	new.Delete(new.FindId("44"))
+	new.FindId("3").Data.Name = "Doaa M."
+	new.Children[0], new.Children[1] = new.Children[1], new.Children[0]
+
+	ops, _ := Diff(old, new, eq)
+	adham := old.FindId("6")
+	if err := Apply(old, ops); err != nil {
+		t.Fatal(err)
+	}
+	if ok, m := Equal(old, new, eq, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected trees to be equal, got %v", m)
+	}
+	if old.FindId("6") != adham {
+		t.Errorf("Expected memory address %v to be kept", adham)
+	}
+}
+
+// Testing apply is atomic, failing operation reverts previous ones
+func Test_Apply_Atomic(t *testing.T) {
+	root := newOrgChart()
+	mezo, amr := root.FindId("2"), root.FindId("4")
+	ops := []Op[Person]{
+		{Kind: OpDelete, Id: "44"},
+		{Kind: OpMove, Id: "/0/2/4", Parent: "1", Index: 0},
+		{Kind: OpUpdate, Id: "3", New: Person{Name: "Foo"}},
+		{Kind: OpReorder, Parent: "0", Order: []string{"1", "2"}},
+		{Kind: OpMove, Id: "1", Parent: "6"}, // cycle, Adham is now inside Hager
+	}
+
+	if err := Apply(root, ops); err == nil {
+		t.Fatal("Expected error")
+	}
+	if ok, m := Equal(root, newOrgChart(), func(x, y Person) bool { return x == y }, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected untouched tree, got %v", m)
+	}
+	if mezo.Children[1] != amr {
+		t.Errorf("Expected memory address %v", amr)
+	}
+}
+
+// Testing patch JSON encoding round trip
+func Test_EncodePatch(t *testing.T) {
+	ops := []Op[Person]{
+		{Kind: OpInsert, Id: "7", Parent: "2", Index: 0, New: Person{Name: "Foo"}},
+		{Kind: OpMove, Id: "7", Parent: "1", Index: 1, FromParent: "2"},
+		{Kind: OpReorder, Parent: "1", Order: []string{"7", "3"}},
+	}
+
+	j, err := EncodePatch(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `[{"op":"add","path":"7","parent":"2","index":0,"value":{"Name":"Foo","Age":0,"Boss":""}},` +
+		`{"op":"move","path":"7","parent":"1","index":1,"from":"2","fromIndex":0},` +
+		`{"op":"reorder","parent":"1","order":["7","3"]}]`
+	if j != expect {
+		t.Errorf("Expected %s, got %s", expect, j)
+	}
+
+	decoded, err := DecodePatch[Person](j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newOrgChart()
+	if err := Apply(root, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if root.FindId("1").Children[0].Data.Name != "Foo" {
+		t.Error("Expected Foo to be first child of Hager")
+	}
+}
+
+// Testing three way merge of non conflicting edits
+// Ours moves Amr under Hager and adds a node, theirs renames Amr and deletes Jebril
+func Test_Merge3(t *testing.T) {
+	base, ours, theirs := newOrgChart(), newOrgChart(), newOrgChart()
+	eq := func(x, y Person) bool {
+		return x == y
+	}
+
+	Apply(ours, []Op[Person]{
+		{Kind: OpMove, Id: "4", Parent: "1", Index: 0},
+		{Kind: OpInsert, Id: "7", Parent: "2", New: Person{Name: "Foo"}},
+	})
+	Apply(theirs, []Op[Person]{
+		{Kind: OpUpdate, Id: "4", New: Person{Name: "Amr M.", Age: 24, Boss: "Mezo"}},
+		{Kind: OpDelete, Id: "44"},
+	})
+
+	merged, conflicts, err := Merge3(base, ours, theirs, nil)
+	if err != nil || len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %v %v", conflicts, err)
+	}
+
+	expect := newOrgChart()
+	Apply(expect, []Op[Person]{
+		{Kind: OpMove, Id: "4", Parent: "1", Index: 0},
+		{Kind: OpInsert, Id: "7", Parent: "2", New: Person{Name: "Foo"}},
+		{Kind: OpUpdate, Id: "4", New: Person{Name: "Amr M.", Age: 24, Boss: "Mezo"}},
+		{Kind: OpDelete, Id: "44"},
+	})
+	if ok, m := Equal(merged, expect, eq, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Unexpected merge result, %v", m)
+	}
+	if ok, _ := Equal(base, newOrgChart(), eq, EqualOptions{CompareId: true}); !ok {
+		t.Error("Expected base to be untouched")
+	}
+}
+
+// Testing three way merge conflicts
+func Test_Merge3_Conflicts(t *testing.T) {
+	base, ours, theirs := newOrgChart(), newOrgChart(), newOrgChart()
+
+	Apply(ours, []Op[Person]{
+		{Kind: OpMove, Id: "3", Parent: "2"},
+		{Kind: OpDelete, Id: "44"},
+		{Kind: OpMove, Id: "2", Parent: "1"},
+	})
+	Apply(theirs, []Op[Person]{
+		{Kind: OpMove, Id: "3", Parent: "4"},
+		{Kind: OpUpdate, Id: "44", New: Person{Name: "Jebril M."}},
+		{Kind: OpMove, Id: "1", Parent: "5"},
+	})
+
+	merged, conflicts, err := Merge3(base, ours, theirs, func(c Conflict[Person]) Resolution {
+		if c.Kind == ConflictMove {
+			return TakeTheirs
+		}
+		return TakeOurs
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"delete/edit conflict on 44", "move conflict on 3", "cycle conflict on 2"}
+	if len(conflicts) != len(expect) {
+		t.Fatalf("Expected %v, got %v", expect, conflicts)
+	}
+	for i, c := range conflicts {
+		if c.String() != expect[i] {
+			t.Errorf("Expected %q, got %q", expect[i], c.String())
+		}
+	}
+
+	if merged.FindId("44") != nil {
+		t.Error("Expected Jebril to be deleted")
+	}
+	if p, _ := parentOf(merged, merged.FindId("3")); p == nil || p.Id != "4" {
+		t.Error("Expected Doaa under Amr")
+	}
+	if p, _ := parentOf(merged, merged.FindId("1")); p == nil || p.Id != "5" {
+		t.Error("Expected Hager under Zaher")
+	}
+	if p, _ := parentOf(merged, merged.FindId("2")); p != merged {
+		t.Error("Expected Mezo moved back under Hany")
+	}
+	if merged.Size() != 7 {
+		t.Errorf("Expected 7 nodes, got %v", merged.Size())
+	}
+
+	// an edit below a node deleted by the other side keeps the node
+	base, ours, theirs = newOrgChart(), newOrgChart(), newOrgChart()
+	ours.FindId("6").Data.Name = "Adham M."
+	theirs.FindId("2").Children = slices.DeleteFunc(theirs.FindId("2").Children, func(n *Node[Person]) bool { return n.Id == "4" })
+
+	merged, conflicts, err = Merge3(base, ours, theirs, func(c Conflict[Person]) Resolution { return TakeOurs })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(conflicts) != "[delete/edit conflict on 4 delete/edit conflict on 6]" {
+		t.Errorf("Unexpected conflicts %v", conflicts)
+	}
+	if adham := merged.FindId("6"); adham == nil || adham.Data.Name != "Adham M." || merged.PathOf(adham) != "0/2/4/6" {
+		t.Error("Expected edited Adham kept under Amr")
+	}
+
+	// a kept node restores ancestors the resolution deleted
+	merged, conflicts, _ = Merge3(base, ours, theirs, func(c Conflict[Person]) Resolution {
+		if c.Id == "6" {
+			return TakeOurs
+		}
+		return TakeTheirs
+	})
+	if adham := merged.FindId("6"); adham == nil || merged.PathOf(adham) != "0/2/4/6" {
+		t.Error("Expected kept Adham to restore Amr")
+	}
+	if last := conflicts[len(conflicts)-1]; last.Id != "4" || last.Resolution != TakeBase {
+		t.Errorf("Expected Amr restored from base, got %v", last)
+	}
+}
+
+// Testing concurrent access to a shared tree
+// Run with -race to detect data races
+func Test_SyncTree(t *testing.T) {
+	st := NewSyncTree(newOrgChart())
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			st.Update(func(root *Node[Person]) error {
+				root.FindId("1").AddNode(Person{Name: "Foo"})
+				return nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			st.View(func(root *Node[Person]) {
+				root.Level(2)
+				root.FindBFS("Foo", func(n *Node[Person], s interface{}) bool {
+					return n.Data.Name == s
+				})
+			})
+		}()
+	}
+	wg.Wait()
+
+	st.View(func(root *Node[Person]) {
+		if got := len(root.FindId("1").Children); got != 9 {
+			t.Errorf("Expected 9 children, got %v", got)
+		}
+	})
+
+	expect := errors.New("failed")
+	if err := st.Update(func(*Node[Person]) error { return expect }); err != expect {
+		t.Errorf("Expected %v", expect)
+	}
+}
+
+// Testing a failed update leaves the shared tree as it was
+func Test_SyncTree_Rollback(t *testing.T) {
+	st := NewSyncTree(newOrgChart())
+	var before string
+	var amr *Node[Person]
+	st.View(func(root *Node[Person]) {
+		before, _ = root.SerializeJSON()
+		amr = root.FindId("4")
+	})
+
+	expect := errors.New("failed")
+	err := st.Update(func(root *Node[Person]) error {
+		root.FindId("3").AddNode(Person{Name: "Foo"})
+		root.FindId("6").SetData(Person{Name: "Adam"})
+		root.Move(amr, root.FindId("1"), 0)
+		five := root.FindId("5")
+		root.Delete(five)
+		five.SetData(Person{Name: "Gone"})
+		root.SortChildren(func(a, b *Node[Person]) int { return strings.Compare(a.Id, b.Id) }, true)
+		root.TrimLeaves()
+		return expect
+	})
+	if err != expect {
+		t.Errorf("Expected %v, got %v", expect, err)
+	}
+
+	st.View(func(root *Node[Person]) {
+		if after, _ := root.SerializeJSON(); after != before {
+			t.Errorf("Expected tree reverted\n%s\n%s", before, after)
+		}
+		if root.FindId("4") != amr {
+			t.Error("Expected original nodes kept")
+		}
+		if root.hub != nil {
+			t.Error("Expected tree not attached to an observer hub")
+		}
+	})
+
+	// a panicking transaction doesn't leave changes recorded for the next one
+	func() {
+		defer func() { recover() }()
+		st.Update(func(root *Node[Person]) error {
+			root.AddNode(Person{Name: "Panic"})
+			panic("failed")
+		})
+	}()
+	st.Update(func(root *Node[Person]) error {
+		root.FindId("3").AddNode(Person{Name: "Bar"})
+		return expect
+	})
+	st.View(func(root *Node[Person]) {
+		if len(root.Children) != 3 || root.Children[2].Data.Name != "Panic" {
+			t.Errorf("Expected changes before panic kept, got %s", nodeIds(root.Children))
+		}
+		if len(root.FindId("3").Children) != 0 {
+			t.Error("Expected failed transaction reverted")
+		}
+	})
+}
+
+// Testing persistent tree edits keep older versions intact and share untouched subtrees
+func Test_PNode(t *testing.T) {
+	v1 := Freeze(newOrgChart())
+	mezo, hager := v1.FindId("2"), v1.FindId("1")
+
+	v2, err := v1.AddNode(v1.PathToNode(hager), "7", Person{Name: "Foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Size() != 8 || v2.Size() != 9 {
+		t.Errorf("Expected sizes 8 and 9, got %v and %v", v1.Size(), v2.Size())
+	}
+	if v2.Child(0) != mezo {
+		t.Errorf("Expected shared memory address %v", mezo)
+	}
+
+	v3, err := v2.Move(v2.PathToNode(v2.FindId("4")), v2.PathToNode(v2.FindId("7")), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v3.FindId("7").Child(0).Id(); got != "4" {
+		t.Errorf("Expected Amr under Foo, got %v", got)
+	}
+	if v2.FindId("7").Len() != 0 || v2.FindId("2").Len() != 3 {
+		t.Error("Expected older version to be unchanged")
+	}
+
+	v4, _ := v3.SetData(v3.PathToNode(v3.FindId("6")), Person{Name: "Bar"})
+	if lca, ok := v4.LCA(v4.FindId("6"), v4.FindId("3")); !ok || lca != v4.FindId("1") {
+		t.Errorf("Expected memory address %v", v4.FindId("1"))
+	}
+	if _, ok := v4.LCA(v4.FindId("6"), v3.FindId("6")); ok {
+		t.Error("Expected not found for node outside the tree")
+	}
+	if got := v4.Level(3); len(got) != 1 || got[0] != v4.FindId("4") {
+		t.Errorf("Expected memory address %v at level 3", v4.FindId("4"))
+	}
+
+	if _, err := v4.Move(v4.PathToNode(v4.FindId("1")), v4.PathToNode(v4.FindId("6")), 0); err == nil {
+		t.Error("Expected error moving node into its own subtree")
+	}
+	if _, err := v4.Delete(v3.PathToNode(v3.FindId("3"))); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected %v for path from another version", ErrNodeNotFound)
+	}
+
+	if ok, m := Equal(v1.Thaw(), newOrgChart(), func(x, y Person) bool { return x == y }, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected first version to be unchanged, got %v", m)
+	}
+}
+
+// Testing moving a node
+func Test_Move(t *testing.T) {
+	root := newOrgChart()
+	amr, hager := root.FindId("4"), root.FindId("1")
+
+	if err := root.Move(amr, hager, 0); err != nil {
+		t.Fatal(err)
+	}
+	if hager.Children[0] != amr || len(root.FindId("2").Children) != 2 {
+		t.Errorf("Expected memory address %v under Hager", amr)
+	}
+	if err := root.Move(hager, amr.Children[0], 0); err == nil {
+		t.Error("Expected error moving node into its own subtree")
+	}
+	if err := root.Move(&Node[Person]{}, hager, 0); err != ErrNodeNotFound {
+		t.Errorf("Expected %v", ErrNodeNotFound)
+	}
+}
+
+// Testing undo and redo of tracked mutations
+func Test_History(t *testing.T) {
+	root := newOrgChart()
+	h := NewHistory(root, 0)
+	eq := func(x, y Person) bool { return x == y }
+	amr, hager := root.FindId("4"), root.FindId("1")
+
+	foo := h.AddNode(hager, Person{Name: "Foo"})
+	h.Move(amr, foo, 0)
+	h.SetData(amr, Person{Name: "Amr M."})
+	h.Delete(root.FindId("44"))
+	after := Freeze(root).Thaw()
+
+	for h.Undo() {
+	}
+	if ok, m := Equal(root, newOrgChart(), eq, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected original tree after undo, got %v", m)
+	}
+	if root.FindId("2").Children[1] != amr {
+		t.Errorf("Expected memory address %v", amr)
+	}
+
+	for h.Redo() {
+	}
+	if ok, m := Equal(root, after, eq, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected edited tree after redo, got %v", m)
+	}
+	if foo.Children[0] != amr {
+		t.Errorf("Expected memory address %v under Foo", amr)
+	}
+}
+
+// Testing transactions and history limit
+func Test_History_Transaction(t *testing.T) {
+	root := newOrgChart()
+	h := NewHistory(root, 2)
+
+	h.TrimLeaves()
+	if root.Size() != 4 {
+		t.Errorf("Expected 4 nodes after trimming, got %v", root.Size())
+	}
+	h.Undo()
+	if root.Size() != 8 {
+		t.Errorf("Expected trimming undone in one step, got %v nodes", root.Size())
+	}
+
+	err := h.Transaction(func() error {
+		h.AddNode(root, Person{Name: "Foo"})
+		return errors.New("failed")
+	})
+	if err == nil || root.Size() != 8 {
+		t.Error("Expected failed transaction to be reverted")
+	}
+
+	for i := 0; i < 3; i++ {
+		h.AddNode(root, Person{Name: "Foo"})
+	}
+	undone := 0
+	for h.Undo() {
+		undone++
+	}
+	if undone != 2 || root.Size() != 9 {
+		t.Errorf("Expected 2 undo steps, got %v", undone)
+	}
+}
+
+func Test_History_NestedTransaction(t *testing.T) {
+	root := newOrgChart()
+	h := NewHistory(root, 0)
+	rename := func(id, name string) {
+		h.SetData(root.FindId(id), Person{Name: name})
+	}
+	failed := errors.New("failed")
+
+	// inner failure reverts only the inner changes, the outer failure reverts the rest
+	err := h.Transaction(func() error {
+		rename("1", "one")
+		if err := h.Transaction(func() error {
+			rename("3", "three")
+			return failed
+		}); err == nil {
+			t.Error("Expected inner error")
+		}
+		rename("5", "five")
+		return failed
+	})
+	if err == nil || root.FindId("1").Data.Name != "Hager" || root.FindId("3").Data.Name != "Doaa" || root.FindId("5").Data.Name != "Zaher" {
+		t.Error("Expected all changes reverted")
+	}
+	if h.CanUndo() {
+		t.Error("Expected nothing recorded")
+	}
+
+	// outer commit keeps changes made around the failed inner transaction as one step
+	h.Transaction(func() error {
+		rename("1", "one")
+		h.Transaction(func() error {
+			rename("3", "three")
+			return failed
+		})
+		rename("5", "five")
+		return nil
+	})
+	if root.FindId("1").Data.Name != "one" || root.FindId("3").Data.Name != "Doaa" || root.FindId("5").Data.Name != "five" {
+		t.Error("Expected outer changes kept and inner changes reverted")
+	}
+	if !h.Undo() || h.CanUndo() || root.FindId("1").Data.Name != "Hager" || root.FindId("5").Data.Name != "Zaher" {
+		t.Error("Expected outer changes undone in one step")
+	}
+}
+
+// Testing mutation events scoped to a subtree
+func Test_Subscribe(t *testing.T) {
+	root := newOrgChart()
+	mezo, amr, hager := root.FindId("2"), root.FindId("4"), root.FindId("1")
+	zaher, jebril := root.FindId("5"), root.FindId("44")
+
+	events := make([]Event[Person], 0)
+	sub := mezo.Subscribe(func(e Event[Person]) {
+		events = append(events, e)
+	})
+
+	foo := amr.AddNode(Person{Name: "Foo"})
+	hager.AddNode(Person{Name: "Bar"})  // outside scope
+	root.Move(amr, hager, 0)            // moved out of scope
+	amr.SetData(Person{Name: "Amr M."}) // outside scope now
+	zaher.SetData(Person{Name: "Zaher M."})
+	root.Delete(jebril)
+
+	expect := []Event[Person]{
+		{Kind: NodeAdded, Node: foo, NewParent: amr, Index: 1},
+		{Kind: NodeMoved, Node: amr, OldParent: mezo, NewParent: hager, OldIndex: 1, Index: 0},
+		{Kind: DataChanged, Node: zaher, OldData: Person{Name: "Zaher", Age: 25, Boss: "Mezo"}},
+		{Kind: NodeRemoved, Node: jebril, OldParent: mezo, OldIndex: 1},
+	}
+	if len(events) != len(expect) {
+		t.Fatalf("Expected %v events, got %v", len(expect), events)
+	}
+	for i, e := range events {
+		if e.Kind != expect[i].Kind || e.Node != expect[i].Node || e.OldParent != expect[i].OldParent || e.NewParent != expect[i].NewParent ||
+			e.Index != expect[i].Index || e.OldIndex != expect[i].OldIndex || e.OldData != expect[i].OldData {
+			t.Errorf("Expected event %v, got %v", expect[i], e)
+		}
+	}
+
+	sub.Cancel()
+	mezo.AddNode(Person{Name: "Baz"})
+	if len(events) != len(expect) {
+		t.Error("Expected no events after cancel")
+	}
+}
+
+// Testing mutation events delivered through a channel
+func Test_SubscribeChan(t *testing.T) {
+	root := newOrgChart()
+	sub := root.SubscribeChan(4)
+
+	node := root.FindId("3").AddNode(Person{Name: "Foo"})
+	Apply(root, []Op[Person]{
+		{Kind: OpMove, Id: "3", Parent: "2"},
+		{Kind: OpDelete, Id: "9"}, // fails, move is reverted
+	})
+	sub.Cancel()
+
+	kinds := []EventKind{}
+	for e := range sub.C() {
+		kinds = append(kinds, e.Kind)
+	}
+	expect := []EventKind{NodeAdded, NodeMoved, NodeMoved}
+	if !slices.Equal(kinds, expect) {
+		t.Errorf("Expected %v, got %v", expect, kinds)
+	}
+	if root.FindId("1").Children[0].Children[0] != node {
+		t.Errorf("Expected memory address %v", node)
+	}
+}
+
+// Testing removed subtrees are released by the hub
+func Test_SubscribeRemoved(t *testing.T) {
+	root := newOrgChart()
+	sub := root.Subscribe(func(Event[Person]) {})
+	defer sub.Cancel()
+
+	mezo := root.FindId("2")
+	root.Delete(mezo)
+	walkPreOrder(mezo, func(n, _ *Node[Person], _ int) {
+		if n.hub != nil || root.hub.parent[n] != nil {
+			t.Errorf("Expected %s to be released", n.Id)
+		}
+	})
+	if len(root.hub.parent) != 2 {
+		t.Errorf("Expected parents of 1 and 3 only, got %d", len(root.hub.parent))
+	}
+
+	// subtrees observed from inside stay attached
+	events := 0
+	hager := root.FindId("1")
+	inner := hager.Subscribe(func(Event[Person]) { events++ })
+	defer inner.Cancel()
+	root.Delete(hager)
+	hager.FindId("3").AddNode(Person{Name: "Foo"})
+	if events != 2 {
+		t.Errorf("Expected 2 events, got %d", events)
+	}
+}
+
+// Testing reorder events are reported only when the order changes
+func Test_SubscribeReorder(t *testing.T) {
+	root := newOrgChart()
+	events := []Event[Person]{}
+	sub := root.Subscribe(func(e Event[Person]) { events = append(events, e) })
+	defer sub.Cancel()
+
+	byId := func(a, b *Node[Person]) int { return strings.Compare(a.Id, b.Id) }
+	root.SortChildren(byId, false)
+	root.SortChildren(byId, false)
+	if len(events) != 1 || events[0].Kind != ChildrenReordered || events[0].Node != root || nodeIds(events[0].OldChildren) != "2,1" {
+		t.Errorf("Expected one reorder of root, got %v", events)
+	}
+}
+
+// Testing cancel while a channel send is blocked on a full buffer
+func Test_SubscribeChanCancel(t *testing.T) {
+	root := newOrgChart()
+	sub := root.SubscribeChan(1)
+
+	mutated := make(chan struct{})
+	go func() {
+		root.AddNode(Person{Name: "Foo"})
+		root.AddNode(Person{Name: "Bar"}) // blocks until canceled
+		close(mutated)
+	}()
+	for len(sub.C()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	canceled := make(chan struct{})
+	go func() {
+		sub.Cancel()
+		close(canceled)
+	}()
+	for _, ch := range []chan struct{}{canceled, mutated} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Cancel deadlocked with a blocked send")
+		}
+	}
+
+	if e, ok := <-sub.C(); !ok || e.Node.Data.Name != "Foo" {
+		t.Error("Expected buffered event before close")
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Error("Expected closed channel")
+	}
+	if len(root.Children) != 4 {
+		t.Errorf("Expected both nodes added, got %d children", len(root.Children))
+	}
+}
+
+// Testing store recovery from log and snapshot
+func Test_Store(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore[Person](dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.AddNode("", "0", Person{Name: "Hany"})
+	s.AddNode("0", "1", Person{Name: "Hager"})
+	s.AddNode("0", "2", Person{Name: "Mezo"})
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.AddNode("2", "4", Person{Name: "Amr"})
+	s.Move("4", "1", 0)
+	s.SetData("4", Person{Name: "Amr M."})
+	if err := s.Delete("9"); err == nil {
+		t.Error("Expected error deleting missing node")
+	}
+	s.Close()
+
+	s, err = OpenStore[Person](dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.View(func(root *Node[Person]) {
+		if root.Size() != 5 {
+			t.Errorf("Expected 5 nodes, got %v", root.Size())
+		}
+		if got := root.FindId("1").Children[0]; got.Id != "4" || got.Data.Name != "Amr M." {
+			t.Errorf("Expected Amr M. under Hager, got %v", got.Data)
+		}
+	})
+}
+
+// Testing store survives a torn final record, and automatic snapshots
+func Test_Store_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenStore[Person](dir, StoreOptions{SnapshotEvery: 2})
+	s.AddNode("", "0", Person{Name: "Hany"})
+	s.AddNode("0", "1", Person{Name: "Hager"}) // snapshot
+	s.AddNode("0", "2", Person{Name: "Mezo"})
+	s.Close()
+
+	log := filepath.Join(dir, "wal.log")
+	valid, _ := os.ReadFile(log)
+	torn := append(valid, []byte(`1234abcd {"seq":4,"ops":[{"op":"add","pa`)...)
+	os.WriteFile(log, torn, 0o644)
+
+	s, err := OpenStore[Person](dir, StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.View(func(root *Node[Person]) {
+		if root.Size() != 4 {
+			t.Errorf("Expected 4 nodes, got %v", root.Size())
+		}
+	})
+	if got, _ := os.ReadFile(log); !bytes.Equal(got, valid) {
+		t.Error("Expected torn record to be truncated")
+	}
+
+	s.AddNode("2", "4", Person{Name: "Amr"})
+	s.Close()
+	s, _ = OpenStore[Person](dir, StoreOptions{})
+	s.View(func(root *Node[Person]) {
+		if root.FindId("4") == nil {
+			t.Error("Expected Amr after reopening")
+		}
+	})
+	s.Close()
+}
+
+// Log file failing after writing part of a record
+type partialLog struct {
+	*os.File
+}
+
+func (p partialLog) Write(b []byte) (int, error) {
+	n, _ := p.File.Write(b[:len(b)/2])
+	return n, errors.New("disk full")
+}
+
+func Test_Store_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenStore[Person](dir, StoreOptions{})
+	s.AddNode("", "0", Person{Name: "Hany"})
+
+	file := s.log.(*os.File)
+	s.log = partialLog{file}
+	if err := s.AddNode("0", "1", Person{Name: "Hager"}); err == nil {
+		t.Fatal("Expected write error")
+	}
+	s.View(func(root *Node[Person]) {
+		if root.FindId("1") != nil {
+			t.Error("Expected failed change reverted")
+		}
+	})
+
+	s.log = file
+	s.AddNode("0", "2", Person{Name: "Mezo"})
+	s.Close()
+
+	s, err := OpenStore[Person](dir, StoreOptions{})
+	if err != nil {
+		t.Fatalf("Expected log without partial record, got %v", err)
+	}
+	defer s.Close()
+	s.View(func(root *Node[Person]) {
+		if root.FindId("1") != nil || root.FindId("2") == nil {
+			t.Error("Expected only the written change")
+		}
+	})
+}
+
+func Test_Store_SnapshotError(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "snapshot.json.tmp"), 0o755) // snapshot can't be written
+
+	var snapshotErr error
+	s, _ := OpenStore[Person](dir, StoreOptions{SnapshotEvery: 1, OnSnapshotError: func(err error) { snapshotErr = err }})
+	if err := s.AddNode("", "0", Person{Name: "Hany"}); err != nil {
+		t.Errorf("Expected logged change to succeed, got %v", err)
+	}
+	if snapshotErr == nil {
+		t.Error("Expected snapshot error reported")
+	}
+	s.Close()
+
+	s, _ = OpenStore[Person](dir, StoreOptions{})
+	defer s.Close()
+	s.View(func(root *Node[Person]) {
+		if root.FindId("0") == nil {
+			t.Error("Expected change recovered from the log")
+		}
+	})
+}
+
+// Testing binary encoding round trip with default payload codec
+func Test_MarshalBinary(t *testing.T) {
+	root := newOrgChart()
+	data, err := root.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := Tree[Person]()
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if ok, m := Equal(root, got, func(x, y Person) bool { return x == y }, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected equal trees, got %v", m)
+	}
+
+	if j, _ := root.SerializeJSON(); len(data) >= len(j) {
+		t.Errorf("Expected binary smaller than JSON, got %d against %d bytes", len(data), len(j))
+	}
+
+	data[len(data)/2] ^= 0xff
+	if err := got.UnmarshalBinary(data); err == nil {
+		t.Error("Expected error for corrupted data")
+	}
+}
+
+// Testing streaming binary encoding with a user payload codec
+func Test_EncodeBinary(t *testing.T) {
+	codec := FuncCodec[Person]{
+		EncodeFunc: func(p Person) ([]byte, error) {
+			return []byte(p.Name), nil
+		},
+		DecodeFunc: func(b []byte) (Person, error) {
+			return Person{Name: string(b)}, nil
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeBinary(&buf, newOrgChart(), codec); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("GTRB\x01\x010\x02\x04Hany")) {
+		t.Errorf("Unexpected header %q", buf.Bytes()[:12])
+	}
+
+	root, err := DecodeBinary(&buf, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, m := Equal(root, newOrgChart(), func(x, y Person) bool { return x.Name == y.Name }, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected equal trees, got %v", m)
+	}
+
+	if _, err := DecodeBinary(bytes.NewReader([]byte("GTRB\x02")), codec); err == nil {
+		t.Error("Expected error for unsupported version")
+	}
+
+	// decoding stops at the end of a tree, with or without io.ByteReader
+	buf.Reset()
+	EncodeBinary(&buf, newOrgChart(), codec)
+	EncodeBinary(&buf, newOrgChart().FindId("1"), codec)
+	buf.WriteString("trailer")
+	stream := struct{ io.Reader }{&buf} // hides io.ByteReader
+	for _, r := range []io.Reader{bytes.NewReader(buf.Bytes()), stream} {
+		first, err := DecodeBinary(r, codec)
+		if err != nil || first.Size() != 8 {
+			t.Fatalf("Expected first tree, got %v", err)
+		}
+		second, err := DecodeBinary(r, codec)
+		if err != nil || second.Id != "1" || second.Size() != 2 {
+			t.Fatalf("Expected second tree, got %v", err)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "trailer" {
+			t.Errorf("Expected trailer, got %q", rest)
+		}
+	}
+
+	// io.EOF marks a clean end of stream only
+	if _, err := DecodeBinary(bytes.NewReader(nil), codec); err != io.EOF {
+		t.Errorf("Expected %v, got %v", io.EOF, err)
+	}
+	buf.Reset()
+	EncodeBinary(&buf, newOrgChart(), codec)
+	for _, size := range []int{5, 6, buf.Len() - 4, buf.Len() - 1} {
+		if _, err := DecodeBinary(bytes.NewReader(buf.Bytes()[:size]), codec); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected %v for %d bytes, got %v", io.ErrUnexpectedEOF, size, err)
+		}
+	}
+	if err := Tree[Person]().UnmarshalBinary([]byte("GTRB\x01\x00\xff\xff\xff\xff\x0f")); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+// Testing nested XML encoding round trip
+func Test_EncodeXML(t *testing.T) {
+	var buf bytes.Buffer
+	opts := XMLOptions[Person]{Element: "employee", IdAttr: "ref"}
+	if err := EncodeXML(&buf, newOrgChart(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := `<employee ref="0"><data><Name>Hany</Name><Age>41</Age><Boss></Boss></data><employee ref="2">`
+	if !strings.HasPrefix(buf.String(), prefix) {
+		t.Errorf("Expected prefix %s, got %s", prefix, buf.String())
+	}
+
+	root, err := DecodeXML(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, m := Equal(root, newOrgChart(), func(x, y Person) bool { return x == y }, EqualOptions{CompareId: true}); !ok {
+		t.Errorf("Expected equal trees, got %v", m)
+	}
+}
+
+// Testing OPML profile
+func Test_DecodeOPML(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<opml version="2.0">
+  <head><title>Feeds</title></head>
+  <body>
+    <outline text="Go">
+      <outline text="Go Blog" type="rss" xmlUrl="https://go.dev/blog/feed.atom" lang="en"/>
+    </outline>
+    <outline text="Misc"/>
+  </body>
+</opml>`
+
+	root, err := DecodeOPML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Data.Title != "Feeds" || len(root.Children) != 2 || root.Size() != 4 {
+		t.Fatalf("Unexpected tree %v with %v nodes", root.Data, root.Size())
+	}
+	blog := root.Children[0].Children[0].Data
+	if blog.XMLURL != "https://go.dev/blog/feed.atom" || len(blog.Attrs) != 1 || blog.Attrs[0].Value != "en" {
+		t.Errorf("Unexpected outline %v", blog)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeOPML(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+	again, err := DecodeOPML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq := func(x, y Outline) bool {
+		return x.Text == y.Text && x.Title == y.Title && x.XMLURL == y.XMLURL && len(x.Attrs) == len(y.Attrs)
+	}
+	if ok, m := Equal(root, again, eq, EqualOptions{}); !ok {
+		t.Errorf("Expected equal trees, got %v", m)
+	}
+
+	if err := EncodeOPML(&buf, nil); err == nil {
+		t.Error("Expected error for nil tree")
+	}
+	if root, err := DecodeXML(strings.NewReader(`<node id="0"><node id="1">`), XMLOptions[Person]{}); err == nil || root != nil {
+		t.Errorf("Expected error and no tree for truncated document, got %v", root)
+	}
+}
+
+// Testing building a tree from a file system
+func Test_FromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/readme.md":   {Data: []byte("# readme")},
+		"docs/api/tree.md": {Data: []byte("# tree")},
+		"docs/api/node.md": {Data: []byte("# node")},
+		"main.go":          {Data: []byte("package main")},
+	}
+
+	root, err := FromFS(fsys, "docs", func(p string, d fs.DirEntry) (bool, error) {
+		return d.IsDir(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Size() != 5 || root.FindId("docs/api/tree.md") == nil || root.FindId("main.go") != nil {
+		t.Errorf("Unexpected tree with %v nodes", root.Size())
+	}
+	if api := root.FindId("docs/api"); !api.Data || api.Children[0].Id != "docs/api/node.md" {
+		t.Error("Expected docs/api directory with sorted entries")
+	}
+}
+
+// Testing a tree exposed as a file system
+func Test_NewFS(t *testing.T) {
+	reads := 0
+	fsys := NewFS(newOrgChart(),
+		func(n *Node[Person]) string {
+			return n.Data.Name
+		},
+		func(n *Node[Person]) ([]byte, error) {
+			reads++
+			return []byte(fmt.Sprintf("%s reports to %s\n", n.Data.Name, n.Data.Boss)), nil
+		},
+	)
+
+	// listing directories doesn't fetch file content
+	fs.WalkDir(fsys, ".", func(string, fs.DirEntry, error) error { return nil })
+	if reads != 0 {
+		t.Errorf("Expected no content reads while walking, got %d", reads)
+	}
+
+	if err := fstest.TestFS(fsys, "Mezo/Amr/Adham", "Mezo/Zaher", "Hager/Doaa"); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := fs.ReadFile(fsys, "Mezo/Amr/Adham")
+	if err != nil || string(content) != "Adham reports to Amr\n" {
+		t.Errorf("Unexpected content %q", content)
+	}
+	if _, err := fs.Stat(fsys, "Mezo/Foo"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected %v", fs.ErrNotExist)
+	}
+}
+
+// Testing Id path addressing
+func Test_Get(t *testing.T) {
+	root := newOrgChart()
+	adham := root.FindId("6")
+
+	if got := root.Get("0/2/4/6"); got != adham {
+		t.Errorf("Expected memory address %v", adham)
+	}
+	if got := root.Get("0/1/4"); got != nil {
+		t.Errorf("Expected nil, got %v", got)
+	}
+	if got := root.PathOf(adham); got != "0/2/4/6" {
+		t.Errorf("Expected 0,2,4,6, got %v", got)
+	}
+
+	// repeated Ids are told apart by location
+	adham.Id = "5"
+	if got := root.Get("0/2/4/5"); got != adham {
+		t.Errorf("Expected memory address %v", adham)
+	}
+}
+
+// Testing glob patterns over Id paths
+func Test_Glob(t *testing.T) {
+	root := newOrgChart()
+
+	tests := []struct {
+		pattern string
+		expect  []string
+	}{
+		{"0/*/4", []string{"4"}},
+		{"0/*", []string{"2", "1"}},
+		{"**/6", []string{"6"}},
+		{"0/**/[35]", []string{"5", "3"}},
+		{"0/2/**", []string{"5", "4", "6", "44"}},
+		{"1/**", []string{}},
+	}
+	for _, tt := range tests {
+		got, err := root.Glob(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(got))
+		for i, n := range got {
+			ids[i] = n.Id
+		}
+		if !slices.Equal(ids, tt.expect) {
+			t.Errorf("%s: expected %v, got %v", tt.pattern, tt.expect, ids)
+		}
+	}
+
+	if _, err := root.Glob("0/["); err == nil {
+		t.Error("Expected error for bad pattern")
+	}
+}
+
+func Test_Query(t *testing.T) {
+	root := newOrgChart()
+
+	ids := func(nodes []*Node[Person]) []string {
+		res := make([]string, len(nodes))
+		for i, n := range nodes {
+			res[i] = n.Id
+		}
+		return res
+	}
+
+	queries := []struct {
+		expr   string
+		expect []string
+	}{
+		{"//*[depth>1][leaf]/..", []string{"2", "4", "1"}},
+		{"/", []string{"0"}},
+		{"/0/2/4", []string{"4"}},
+		{"*", []string{"2", "1"}},
+		{"2/*[2]", []string{"4"}},
+		{"2/*[last()]", []string{"44"}},
+		{"//6/ancestor::*", []string{"0", "2", "4"}},
+		{"//6/ancestor::*[1]", []string{"4"}},
+		{"//*[@Age>=38]", []string{"0", "2", "1"}},
+		{"//*[@Name='Amr']/following-sibling::*", []string{"44"}},
+		{"//*[@Name='Amr']/preceding-sibling::*", []string{"5"}},
+		{"//4/sibling::*", []string{"5", "44"}},
+		{"//*[children=1]", []string{"4", "1"}},
+		{"//*[@Boss='']", []string{"0"}},
+		{"//*[@Nope]", []string{}},
+	}
+	for _, tt := range queries {
+		got, err := root.Query(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids(got), tt.expect) {
+			t.Errorf("%s: expected %v, got %v", tt.expr, tt.expect, ids(got))
+		}
+	}
+
+	selectors := []struct {
+		sel    string
+		expect []string
+	}{
+		{"2 > *:nth-child(2)", []string{"4"}},
+		{"0 6", []string{"6"}},
+		{"5 + 4", []string{"4"}},
+		{"5 ~ *", []string{"4", "44"}},
+		{":root", []string{"0"}},
+		{"[Boss=Mezo]:last-child", []string{"44"}},
+		{"#3", []string{"3"}},
+		{"2 :leaf", []string{"5", "6", "44"}},
+		{"*[Age<20]", []string{"6"}},
+		{"* > *", []string{"2", "5", "4", "6", "44", "1", "3"}},
+		{"* 0", []string{}},
+		{"* > 0", []string{}},
+		{"0", []string{"0"}},
+	}
+	for _, tt := range selectors {
+		got, err := root.Select(tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids(got), tt.expect) {
+			t.Errorf("%s: expected %v, got %v", tt.sel, tt.expect, ids(got))
+		}
+	}
+
+	for _, expr := range []string{"//*[", "foo::x", "//*[bogus]", "2/", "*[@Age>]", "2..", "//4/..."} {
+		if _, err := CompileQuery(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+	for _, sel := range []string{"2 >", ":nope", "[Age", "> 2"} {
+		if _, err := CompileSelector(sel); err == nil {
+			t.Errorf("%s: expected error", sel)
+		}
+	}
+
+	// compiled queries are reusable across trees
+	q := MustCompileQuery("//*[leaf]")
+	if got := ids(Exec(q, root)); !slices.Equal(got, []string{"5", "6", "44", "3"}) {
+		t.Errorf("Expected leaves, got %v", got)
+	}
+	other := &Node[string]{Id: "a", Children: []*Node[string]{{Id: "b"}}}
+	if got := Exec(q, other); len(got) != 1 || got[0].Id != "b" {
+		t.Errorf("Expected b, got %v", got)
+	}
+}
+
+func Test_Cursor(t *testing.T) {
+	root := newOrgChart()
+	c := root.Cursor()
+
+	if c.Parent() || c.NextSibling() || c.Index() != -1 {
+		t.Error("Expected root cursor not to move")
+	}
+	if !c.FirstChild() || c.Node().Id != "2" || !c.FirstChild() || c.Node().Id != "5" {
+		t.Fatalf("Expected 5, got %s", c.Node().Id)
+	}
+	if !c.NextSibling() || c.Node().Id != "4" || c.Index() != 1 || c.Depth() != 2 {
+		t.Errorf("Expected 4 at index 1, got %s at %d", c.Node().Id, c.Index())
+	}
+	if c.PrevSibling(); c.PrevSibling() || c.Node().Id != "5" {
+		t.Errorf("Expected to stay at 5, got %s", c.Node().Id)
+	}
+	if !c.Parent() || !c.LastChild() || c.Node().Id != "44" || c.NextSibling() {
+		t.Errorf("Expected 44, got %s", c.Node().Id)
+	}
+	if c.Root(); c.Node() != root || c.Depth() != 0 {
+		t.Error("Expected root")
+	}
+
+	target := root.FindId("6")
+	c, err := root.CursorAt(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeIds(c.Path()); ids != "0,2,4,6" {
+		t.Errorf("Expected 0,2,4,6, got %s", ids)
+	}
+	if _, err := root.CursorAt(&Node[Person]{}); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	// editing around 4
+	c.Parent()
+	before, after := &Node[Person]{Id: "b"}, &Node[Person]{Id: "a"}
+	if err := c.InsertBefore(before); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InsertAfter(after); err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeIds(root.FindId("2").Children); ids != "5,b,4,a,44" {
+		t.Errorf("Expected 5,b,4,a,44, got %s", ids)
+	}
+	if c.Node().Id != "4" || c.Index() != 2 {
+		t.Errorf("Expected cursor at 4 index 2, got %s at %d", c.Node().Id, c.Index())
+	}
+
+	c.AppendChild(&Node[Person]{Id: "7"})
+	if ids := nodeIds(c.Node().Children); ids != "6,7" {
+		t.Errorf("Expected 6,7, got %s", ids)
+	}
+
+	replaced := &Node[Person]{Id: "r"}
+	if err := c.Replace(replaced); err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeIds(root.FindId("2").Children); ids != "5,b,r,a,44" || c.Node() != replaced {
+		t.Errorf("Expected 5,b,r,a,44, got %s", ids)
+	}
+
+	if err := c.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Node().Id != "a" || nodeIds(root.FindId("2").Children) != "5,b,a,44" {
+		t.Errorf("Expected cursor at a, got %s", c.Node().Id)
+	}
+	c.LastChild()
+	c.NextSibling()
+	c.Remove()
+	c.Remove()
+	if c.Node().Id != "b" {
+		t.Errorf("Expected cursor at b, got %s", c.Node().Id)
+	}
+
+	// the tree changed outside the cursor
+	root.Delete(c.Node())
+	if err := c.Remove(); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	c.Root()
+	if c.Remove() == nil || c.InsertAfter(&Node[Person]{}) == nil || c.Replace(&Node[Person]{}) == nil {
+		t.Error("Expected root edits to fail")
+	}
+}
+
+func Test_Zipper(t *testing.T) {
+	root := newOrgChart()
+	before, _ := root.SerializeJSON()
+
+	z := root.Zipper()
+	if _, ok := z.Up(); ok {
+		t.Error("Expected root not to move up")
+	}
+	if z.Root() != root {
+		t.Error("Expected unchanged root")
+	}
+
+	// walk to 4 and back without edits
+	z, _ = z.Down(0)
+	z, _ = z.Down(1)
+	if z.Node().Id != "4" || z.Depth() != 2 || z.Index() != 1 {
+		t.Fatalf("Expected 4, got %s", z.Node().Id)
+	}
+	if z.Root() != root {
+		t.Error("Expected unchanged root after navigation")
+	}
+
+	renamed := z.SetData(Person{Name: "Amro"})
+	left, _ := renamed.InsertLeft(&Node[Person]{Id: "l"})
+	right, _ := left.InsertRight(&Node[Person]{Id: "r"})
+	next, _ := right.Right()
+	next, _ = next.Right()
+	edited := next.SetData(Person{Name: "Jebrila"}).Root()
+
+	if after, _ := root.SerializeJSON(); after != before {
+		t.Error("Expected original tree untouched")
+	}
+	if ids := nodeIds(edited.FindId("2").Children); ids != "5,l,4,r,44" {
+		t.Errorf("Expected 5,l,4,r,44, got %s", ids)
+	}
+	if edited.FindId("4").Data.Name != "Amro" || edited.FindId("44").Data.Name != "Jebrila" {
+		t.Error("Expected edited data")
+	}
+	if edited.FindId("1") != root.FindId("1") || edited.FindId("6") != root.FindId("6") {
+		t.Error("Expected unchanged subtrees to be shared")
+	}
+
+	// earlier zippers stay valid
+	if got := renamed.Root(); nodeIds(got.FindId("2").Children) != "5,4,44" || got.FindId("4").Data.Name != "Amro" {
+		t.Error("Expected earlier zipper to keep its own edits")
+	}
+
+	removed, err := z.Remove()
+	if err != nil || removed.Node().Id != "44" {
+		t.Fatalf("Expected focus on 44, got %v", err)
+	}
+	removed, _ = removed.Remove()
+	if removed.Node().Id != "5" {
+		t.Errorf("Expected focus on 5, got %s", removed.Node().Id)
+	}
+	removed, _ = removed.Remove()
+	if removed.Node().Id != "2" || len(removed.Node().Children) != 0 {
+		t.Errorf("Expected focus on empty 2, got %s", removed.Node().Id)
+	}
+	if ids := nodeIds(removed.AppendChild(&Node[Person]{Id: "c"}).Root().FindId("2").Children); ids != "c" {
+		t.Errorf("Expected c, got %s", ids)
+	}
+	if len(root.FindId("2").Children) != 3 {
+		t.Error("Expected original tree untouched")
+	}
+
+	if _, err := root.Zipper().Remove(); err == nil {
+		t.Error("Expected error removing root")
+	}
+	if _, err := root.Zipper().InsertLeft(&Node[Person]{}); err == nil {
+		t.Error("Expected error inserting next to root")
+	}
+}
+
+func Test_LCAIndex(t *testing.T) {
+	root := newOrgChart()
+	ix := NewLCAIndex(root)
+	nodes := []*Node[Person]{}
+	walkPreOrder(root, func(node, _ *Node[Person], _ int) {
+		nodes = append(nodes, node)
+	})
+
+	// compare against tree walking queries for all pairs
+	for _, p := range nodes {
+		for _, q := range nodes {
+			lca, ok := ix.LCA(p, q)
+			if expect, _ := root.LCA(p, q); !ok || lca != expect {
+				t.Errorf("LCA(%s, %s): expected %s, got %v", p.Id, q.Id, expect.Id, lca)
+			}
+			path, err := ix.PathN2N(p, q)
+			if err != nil || nodeIds(path) != nodeIds(root.PathN2N(p, q)) {
+				t.Errorf("PathN2N(%s, %s): expected %s, got %s", p.Id, q.Id, nodeIds(root.PathN2N(p, q)), nodeIds(path))
+			}
+			if d, _ := ix.Distance(p, q); d != len(path)-1 {
+				t.Errorf("Distance(%s, %s): expected %d, got %d", p.Id, q.Id, len(path)-1, d)
+			}
+			anc, _ := ix.IsAncestor(p, q)
+			if expect := p != q && slices.Contains(root.PathToNode(q), p); anc != expect {
+				t.Errorf("IsAncestor(%s, %s): expected %v", p.Id, q.Id, expect)
+			}
+		}
+	}
+
+	adham := root.FindId("6")
+	for k, id := range []string{"6", "4", "2", "0"} {
+		if got, err := ix.KthAncestor(adham, k); err != nil || got.Id != id {
+			t.Errorf("KthAncestor(6, %d): expected %s, got %v", k, id, got)
+		}
+	}
+	if _, err := ix.KthAncestor(adham, 4); err == nil {
+		t.Error("Expected error above root")
+	}
+	if d, _ := ix.Depth(adham); d != 3 {
+		t.Errorf("Expected depth 3, got %d", d)
+	}
+
+	outside := &Node[Person]{Id: "x"}
+	if _, ok := ix.LCA(adham, outside); ok {
+		t.Error("Expected LCA not found")
+	}
+	if _, err := ix.IsAncestor(root, outside); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+	if _, err := ix.PathN2N(outside, adham); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	// deep trees are indexed without recursion
+	chain := &Node[int]{Id: "0"}
+	last := chain
+	for i := 1; i < 100000; i++ {
+		last = last.AddNode(i)
+	}
+	deep := NewLCAIndex(chain)
+	if got, _ := deep.KthAncestor(last, 99999); got != chain {
+		t.Error("Expected root as farthest ancestor")
+	}
+	if d, _ := deep.Distance(chain, last); d != 99999 {
+		t.Errorf("Expected distance 99999, got %d", d)
+	}
+}
+
+func Test_Relations(t *testing.T) {
+	root := newOrgChart()
+	mezo, amr, adham, doaa := root.FindId("2"), root.FindId("4"), root.FindId("6"), root.FindId("3")
+	outside := &Node[Person]{Id: "x"}
+
+	ancestorTests := []struct {
+		a, b   *Node[Person]
+		expect bool
+	}{
+		{root, adham, true},
+		{mezo, adham, true},
+		{adham, mezo, false},
+		{mezo, doaa, false},
+		{amr, amr, false},
+	}
+	for _, tt := range ancestorTests {
+		if got, err := root.IsAncestor(tt.a, tt.b); err != nil || got != tt.expect {
+			t.Errorf("IsAncestor(%s, %s): expected %v, got %v %v", tt.a.Id, tt.b.Id, tt.expect, got, err)
+		}
+	}
+	if _, err := root.IsAncestor(root, outside); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+	if _, err := root.IsAncestor(outside, adham); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	ancestors, err := root.Ancestors(adham)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeIds(ancestors.Collect()); ids != "4,2,0" {
+		t.Errorf("Expected 4,2,0, got %s", ids)
+	}
+	nearest := []*Node[Person]{}
+	ancestors(func(n *Node[Person]) bool {
+		nearest = append(nearest, n)
+		return false
+	})
+	if len(nearest) != 1 || nearest[0] != amr {
+		t.Error("Expected iteration to stop after nearest ancestor")
+	}
+	if ancestors, _ := root.Ancestors(root); len(ancestors.Collect()) != 0 {
+		t.Error("Expected root to have no ancestors")
+	}
+	if _, err := root.Ancestors(outside); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	if ids := nodeIds(root.Descendants().Collect()); ids != "2,5,4,6,44,1,3" {
+		t.Errorf("Expected 2,5,4,6,44,1,3, got %s", ids)
+	}
+	if len(adham.Descendants().Collect()) != 0 {
+		t.Error("Expected leaf to have no descendants")
+	}
+
+	if siblings, err := root.Siblings(amr); err != nil || nodeIds(siblings) != "5,44" {
+		t.Errorf("Expected 5,44, got %s %v", nodeIds(siblings), err)
+	}
+	if siblings, err := root.Siblings(root); err != nil || len(siblings) != 0 {
+		t.Error("Expected root to have no siblings")
+	}
+	if _, err := root.Siblings(outside); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	distanceTests := []struct {
+		a, b   *Node[Person]
+		expect int
+	}{
+		{adham, adham, 0},
+		{adham, mezo, 2},
+		{adham, doaa, 5},
+		{root, doaa, 2},
+	}
+	for _, tt := range distanceTests {
+		if got, err := root.Distance(tt.a, tt.b); err != nil || got != tt.expect {
+			t.Errorf("Distance(%s, %s): expected %d, got %d %v", tt.a.Id, tt.b.Id, tt.expect, got, err)
+		}
+	}
+	if _, err := root.Distance(adham, outside); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	root := newOrgChart()
+	s := root.Stats()
+
+	if s.Nodes != root.Size() || s.Leaves != len(root.Leaves()) || s.Height != root.Depth() {
+		t.Errorf("Expected 8 nodes, 4 leaves and height 4, got %d, %d and %d", s.Nodes, s.Leaves, s.Height)
+	}
+	for i, w := range s.Widths {
+		if w != len(root.Level(i)) {
+			t.Errorf("Level %d: expected width %d, got %d", i, len(root.Level(i)), w)
+		}
+	}
+	if !slices.Equal(s.Widths, []int{1, 2, 4, 1}) {
+		t.Errorf("Expected widths [1 2 4 1], got %v", s.Widths)
+	}
+
+	b := s.Branching
+	if b.Min != 1 || b.Max != 3 || b.Mean != 1.75 {
+		t.Errorf("Expected branching 1..3 mean 1.75, got %d..%d mean %v", b.Min, b.Max, b.Mean)
+	}
+	expect := map[int]int{0: 4, 1: 2, 2: 1, 3: 1}
+	if fmt.Sprint(b.Histogram) != fmt.Sprint(expect) {
+		t.Errorf("Expected histogram %v, got %v", expect, b.Histogram)
+	}
+
+	if ids := nodeIds(s.DeepestPath); ids != "0,2,4,6" {
+		t.Errorf("Expected 0,2,4,6, got %s", ids)
+	}
+	if s.Widest.Id != "2" {
+		t.Errorf("Expected widest 2, got %s", s.Widest.Id)
+	}
+
+	single := (&Node[int]{Id: "a"}).Stats()
+	if single.Nodes != 1 || single.Leaves != 1 || single.Height != 1 || single.Branching.Mean != 0 || nodeIds(single.DeepestPath) != "a" {
+		t.Errorf("Unexpected single node stats %+v", single)
+	}
+}
+
+func Test_Topology(t *testing.T) {
+	root := newOrgChart()
+
+	if ids := nodeIds(root.Diameter()); ids != "6,4,2,0,1,3" {
+		t.Errorf("Expected diameter 6,4,2,0,1,3, got %s", ids)
+	}
+	if ids := nodeIds(root.Center()); ids != "2,0" {
+		t.Errorf("Expected center 2,0, got %s", ids)
+	}
+	if ids := nodeIds(root.Centroid()); ids != "2" {
+		t.Errorf("Expected centroid 2, got %s", ids)
+	}
+
+	single := &Node[int]{Id: "a"}
+	if nodeIds(single.Diameter()) != "a" || nodeIds(single.Center()) != "a" || nodeIds(single.Centroid()) != "a" {
+		t.Error("Expected single node to be its own diameter, center and centroid")
+	}
+	pair := &Node[int]{Id: "a", Children: []*Node[int]{{Id: "b"}}}
+	if len(pair.Center()) != 2 || nodeIds(pair.Centroid()) != "a,b" {
+		t.Error("Expected both nodes of a pair as center and centroid")
+	}
+
+	// the new root sees its old parent added below it
+	amr := root.FindId("4")
+	events := []Event[Person]{}
+	sub := amr.Subscribe(func(e Event[Person]) { events = append(events, e) })
+	defer sub.Cancel()
+
+	newRoot, err := root.Reroot(amr)
+	if err != nil || newRoot != amr {
+		t.Fatalf("Expected 4 as new root, got %v", err)
+	}
+	if ids := nodeIds(amr.Children); ids != "6,2" {
+		t.Errorf("Expected 6,2, got %s", ids)
+	}
+	if ids := nodeIds(amr.FindId("2").Children); ids != "5,44,0" {
+		t.Errorf("Expected 5,44,0, got %s", ids)
+	}
+	if ids := nodeIds(root.Children); ids != "1" {
+		t.Errorf("Expected 1, got %s", ids)
+	}
+	if amr.Size() != 8 {
+		t.Errorf("Expected 8 nodes, got %d", amr.Size())
+	}
+	if len(events) != 1 || events[0].Kind != NodeAdded || events[0].Node.Id != "2" || events[0].NewParent != amr {
+		t.Errorf("Expected 2 added under 4, got %v", events)
+	}
+	if d := len(amr.Diameter()); d != 6 {
+		t.Errorf("Expected diameter to survive rerooting, got %d nodes", d)
+	}
+
+	if _, err := amr.Reroot(&Node[Person]{}); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+}
+
+func Test_Hash(t *testing.T) {
+	encode := func(p Person) []byte { return []byte(fmt.Sprint(p)) }
+	a, b := newOrgChart(), newOrgChart()
+
+	if !bytes.Equal(a.Hash(encode), b.Hash(encode)) {
+		t.Error("Expected equal trees to hash equally")
+	}
+	b.FindId("6").SetData(Person{Name: "Adam"})
+	if bytes.Equal(a.Hash(encode), b.Hash(encode)) {
+		t.Error("Expected data change to change the hash")
+	}
+	if !bytes.Equal(a.FindId("1").Hash(encode), b.FindId("1").Hash(encode)) {
+		t.Error("Expected untouched subtrees to hash equally")
+	}
+
+	b = newOrgChart()
+	slices.Reverse(b.Children)
+	if bytes.Equal(a.Hash(encode), b.Hash(encode)) {
+		t.Error("Expected children order to change the hash")
+	}
+	ma := NewMerkle(a, encode, HashOptions{Unordered: true})
+	defer ma.Close()
+	mb := NewMerkle(b, encode, HashOptions{Unordered: true})
+	defer mb.Close()
+	if !bytes.Equal(ma.Hash(a), mb.Hash(b)) {
+		t.Error("Expected unordered hashes to ignore children order")
+	}
+}
+
+func Test_Merkle(t *testing.T) {
+	encode := func(p Person) []byte { return []byte(fmt.Sprint(p)) }
+	root := newOrgChart()
+	m := NewMerkle(root, encode, HashOptions{})
+
+	before := slices.Clone(m.Hash(root))
+	if !bytes.Equal(before, root.Hash(encode)) {
+		t.Error("Expected cached hash to match Node.Hash")
+	}
+	hager := root.FindId("1")
+	hagerHash := m.Hash(hager)
+
+	// every mutation must drop the cached hashes above it
+	mutations := []func(){
+		func() { root.FindId("6").SetData(Person{Name: "Adam"}) },
+		func() { root.FindId("3").AddNode(Person{Name: "New"}) },
+		func() { root.Delete(root.FindId("44")) },
+		func() { root.Move(root.FindId("5"), root.FindId("4"), 0) },
+		func() { Apply(root, []Op[Person]{{Kind: OpReorder, Parent: "0", Order: []string{"1", "2"}}}) },
+		func() {
+			root.SortChildren(func(a, b *Node[Person]) int { return strings.Compare(b.Id, a.Id) }, true)
+		},
+		func() {
+			root.KeepSorted(func(a, b *Node[Person]) int { return strings.Compare(a.Id, b.Id) })
+		},
+	}
+	for i, mutate := range mutations {
+		mutate()
+		after := slices.Clone(m.Hash(root))
+		if bytes.Equal(before, after) {
+			t.Errorf("Mutation %d: expected root hash to change", i)
+		}
+		if !bytes.Equal(after, root.Hash(encode)) {
+			t.Errorf("Mutation %d: expected cached hash to match a fresh hash", i)
+		}
+		before = after
+	}
+	if _, ok := m.cache[root.FindId("2")]; !ok {
+		t.Error("Expected hashes to be cached again")
+	}
+	if !bytes.Equal(m.Hash(hager), root.FindId("1").Hash(encode)) || bytes.Equal(m.Hash(hager), hagerHash) {
+		t.Error("Expected hash of 1 to follow the node added below it")
+	}
+
+	// direct changes need explicit invalidation
+	root.FindId("6").Data.Age = 13
+	if !bytes.Equal(m.Hash(root), before) {
+		t.Error("Expected direct change to go unnoticed")
+	}
+	m.Invalidate(root.FindId("6"))
+	if !bytes.Equal(m.Hash(root), root.Hash(encode)) {
+		t.Error("Expected invalidated hash to match a fresh hash")
+	}
+
+	m.Close()
+	before = slices.Clone(m.Hash(root))
+	root.FindId("6").SetData(Person{})
+	if !bytes.Equal(m.Hash(root), before) {
+		t.Error("Expected closed cache to stop following changes")
+	}
+
+	// identical subtrees
+	tree := &Node[string]{Id: "r", Data: "root", Children: []*Node[string]{
+		{Id: "a", Data: "x", Children: []*Node[string]{{Id: "a1", Data: "y"}}},
+		{Id: "b", Data: "x", Children: []*Node[string]{{Id: "b1", Data: "y"}}},
+		{Id: "c", Data: "z"},
+	}}
+	dup := NewMerkle(tree, func(s string) []byte { return []byte(s) }, HashOptions{})
+	defer dup.Close()
+	groups := dup.Duplicates()
+	if len(groups) != 2 || nodeIds(groups[0]) != "a,b" || nodeIds(groups[1]) != "a1,b1" {
+		t.Errorf("Expected groups a,b and a1,b1, got %v", groups)
+	}
+
+	withIds := NewMerkle(tree, func(s string) []byte { return []byte(s) }, HashOptions{IncludeId: true, New: sha256.New224})
+	defer withIds.Close()
+	if len(withIds.Duplicates()) != 0 || len(withIds.Hash(tree)) != sha256.Size224 {
+		t.Error("Expected Ids to tell subtrees apart")
+	}
+}
+
+func Test_Isomorphic(t *testing.T) {
+	a, b := newOrgChart(), newOrgChart()
+	slices.Reverse(b.Children)
+	slices.Reverse(b.FindId("2").Children)
+	name := func(p Person) string { return p.Name }
+
+	tests := []struct {
+		name   string
+		b      *Node[Person]
+		opts   CanonicalOptions[Person]
+		expect bool
+	}{
+		{"same tree", newOrgChart(), CanonicalOptions[Person]{Label: name, IncludeId: true}, true},
+		{"reordered", b, CanonicalOptions[Person]{Label: name}, false},
+		{"reordered ignoring order", b, CanonicalOptions[Person]{Label: name, IgnoreOrder: true, IncludeId: true}, true},
+		{"nil tree", nil, CanonicalOptions[Person]{}, false},
+	}
+	for _, tt := range tests {
+		if got := Isomorphic(a, tt.b, tt.opts); got != tt.expect {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expect, got)
+		}
+	}
+
+	renamed := newOrgChart()
+	renamed.FindId("6").Data.Name = "Adam"
+	if Isomorphic(a, renamed, CanonicalOptions[Person]{Label: name}) {
+		t.Error("Expected renamed node to break isomorphism")
+	}
+	if !Isomorphic(a, renamed, CanonicalOptions[Person]{}) {
+		t.Error("Expected same structure without labels")
+	}
+
+	chain := &Node[int]{Children: []*Node[int]{{Children: []*Node[int]{{}}}}}
+	star := &Node[int]{Children: []*Node[int]{{}, {}}}
+	if Isomorphic(chain, star, CanonicalOptions[int]{IgnoreOrder: true}) {
+		t.Error("Expected chain and star of same size to differ")
+	}
+}
+
+func Test_CanonicalString(t *testing.T) {
+	a, b := newOrgChart(), newOrgChart()
+	slices.Reverse(b.Children)
+	name := func(p Person) string { return p.Name }
+
+	if got := CanonicalString(a.FindId("1"), CanonicalOptions[Person]{Label: name}); got != `("Hager"("Doaa"))` {
+		t.Errorf("Unexpected canonical string %s", got)
+	}
+	if got := CanonicalString(a.FindId("4"), CanonicalOptions[Person]{IncludeId: true}); got != `("4"("6"))` {
+		t.Errorf("Unexpected canonical string %s", got)
+	}
+
+	ordered := CanonicalOptions[Person]{Label: name}
+	unordered := CanonicalOptions[Person]{Label: name, IgnoreOrder: true}
+	if CanonicalString(a, ordered) == CanonicalString(b, ordered) {
+		t.Error("Expected ordered strings to differ")
+	}
+	if CanonicalString(a, unordered) != CanonicalString(b, unordered) {
+		t.Error("Expected unordered strings to match")
+	}
+
+	// group trees by shape
+	shapes := make(map[string]int)
+	for _, tree := range []*Node[Person]{a, b, a.FindId("2"), newOrgChart()} {
+		shapes[CanonicalString(tree, CanonicalOptions[Person]{IgnoreOrder: true})]++
+	}
+	if len(shapes) != 2 {
+		t.Errorf("Expected 2 shapes, got %d", len(shapes))
+	}
+	if CanonicalString[Person](nil, ordered) != "" {
+		t.Error("Expected empty string for nil tree")
+	}
+
+	// canonical strings agree with Isomorphic on random trees and their shuffled copies
+	rnd := rand.New(rand.NewSource(1))
+	random := func() *Node[int] {
+		nodes := []*Node[int]{{Data: rnd.Intn(2)}}
+		for i := 0; i < 12; i++ {
+			parent := nodes[rnd.Intn(len(nodes))]
+			nodes = append(nodes, parent.AddNode(rnd.Intn(2)))
+		}
+		return nodes[0]
+	}
+	opts := CanonicalOptions[int]{IgnoreOrder: true, Label: strconv.Itoa}
+	trees := []*Node[int]{}
+	for i := 0; i < 50; i++ {
+		tree := random()
+		var shuffle func(n *Node[int]) *Node[int]
+		shuffle = func(n *Node[int]) *Node[int] {
+			c := &Node[int]{Data: n.Data}
+			for _, child := range n.Children {
+				c.Children = append(c.Children, shuffle(child))
+			}
+			rnd.Shuffle(len(c.Children), func(i, j int) { c.Children[i], c.Children[j] = c.Children[j], c.Children[i] })
+			return c
+		}
+		shuffled := shuffle(tree)
+		if CanonicalString(tree, opts) != CanonicalString(shuffled, opts) || !Isomorphic(tree, shuffled, opts) {
+			t.Fatal("Expected shuffled copy to be isomorphic")
+		}
+		trees = append(trees, tree)
+	}
+	for _, x := range trees {
+		for _, y := range trees {
+			if (CanonicalString(x, opts) == CanonicalString(y, opts)) != Isomorphic(x, y, opts) {
+				t.Fatal("Expected canonical strings to agree with Isomorphic")
+			}
+		}
+	}
+
+	// deep trees stay linear
+	chain := &Node[int]{}
+	for i, last := 0, chain; i < 50000; i++ {
+		last = last.AddNode(i % 3)
+	}
+	if s := CanonicalString(chain, opts); len(s) < 100000 || !Isomorphic(chain, chain, opts) {
+		t.Error("Expected canonical string of the whole chain")
+	}
+}
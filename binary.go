@@ -0,0 +1,360 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Binary format header, followed by format version.
+var binaryMagic = []byte("GTRB")
+
+// Current binary format version.
+const binaryVersion = 1
+
+// Encodes and decodes node data in the binary format.
+type PayloadCodec[T any] interface {
+	Encode(data T) ([]byte, error)
+	Decode(payload []byte) (T, error)
+}
+
+// Payload codec using user functions.
+type FuncCodec[T any] struct {
+	EncodeFunc func(T) ([]byte, error)
+	DecodeFunc func([]byte) (T, error)
+}
+
+// Encodes data using EncodeFunc.
+func (c FuncCodec[T]) Encode(data T) ([]byte, error) {
+	return c.EncodeFunc(data)
+}
+
+// Decodes payload using DecodeFunc.
+func (c FuncCodec[T]) Decode(payload []byte) (T, error) {
+	return c.DecodeFunc(payload)
+}
+
+// Payload codec using encoding/gob, every payload carries its own type information.
+type GobCodec[T any] struct{}
+
+// Encodes data using encoding/gob.
+func (GobCodec[T]) Encode(data T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&data)
+	return buf.Bytes(), err
+}
+
+// Decodes payload using encoding/gob.
+func (GobCodec[T]) Decode(payload []byte) (T, error) {
+	var data T
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&data)
+	return data, err
+}
+
+// Payload codec for types implementing encoding.BinaryMarshaler, with pointer implementing encoding.BinaryUnmarshaler.
+type MarshalerCodec[T any] struct{}
+
+// Encodes data using its MarshalBinary method.
+func (MarshalerCodec[T]) Encode(data T) ([]byte, error) {
+	m, ok := any(data).(encoding.BinaryMarshaler)
+	if !ok {
+		m, ok = any(&data).(encoding.BinaryMarshaler)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%T doesn't implement encoding.BinaryMarshaler", data)
+	}
+	return m.MarshalBinary()
+}
+
+// Decodes payload using UnmarshalBinary method of data pointer.
+func (MarshalerCodec[T]) Decode(payload []byte) (T, error) {
+	var data T
+	u, ok := any(&data).(encoding.BinaryUnmarshaler)
+	if !ok {
+		return data, fmt.Errorf("%T doesn't implement encoding.BinaryUnmarshaler", &data)
+	}
+	err := u.UnmarshalBinary(payload)
+	return data, err
+}
+
+// Payload codec sharing one encoding/gob stream across all payloads of a tree,
+// so type information is written once in the first payload only.
+// Payloads must be decoded in the order they were encoded, by a fresh codec for every tree.
+type gobStreamCodec[T any] struct {
+	buf bytes.Buffer
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+// Returns a gob stream codec for a single tree.
+func newGobStreamCodec[T any]() *gobStreamCodec[T] {
+	c := &gobStreamCodec[T]{}
+	c.enc = gob.NewEncoder(&c.buf)
+	c.dec = gob.NewDecoder(&c.buf)
+	return c
+}
+
+// Encodes data as the next value of the stream.
+func (c *gobStreamCodec[T]) Encode(data T) ([]byte, error) {
+	c.buf.Reset()
+	if err := c.enc.Encode(&data); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(c.buf.Bytes()), nil
+}
+
+// Decodes the next value of the stream.
+func (c *gobStreamCodec[T]) Decode(payload []byte) (T, error) {
+	var data T
+	c.buf.Write(payload)
+	err := c.dec.Decode(&data)
+	return data, err
+}
+
+// Returns MarshalerCodec if T supports it, a gob stream codec otherwise.
+func defaultCodec[T any]() PayloadCodec[T] {
+	var data T
+	_, m := any(data).(encoding.BinaryMarshaler)
+	_, pm := any(&data).(encoding.BinaryMarshaler)
+	_, u := any(&data).(encoding.BinaryUnmarshaler)
+	if (m || pm) && u {
+		return MarshalerCodec[T]{}
+	}
+	return newGobStreamCodec[T]()
+}
+
+// Encodes tree into binary format, using MarshalerCodec if data supports it, one encoding/gob stream otherwise.
+// Object node is considered root node. See EncodeBinary() for the format.
+func (n *Node[T]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := EncodeBinary(&buf, n, defaultCodec[T]()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes tree from binary format produced by MarshalBinary() into object node.
+func (n *Node[T]) UnmarshalBinary(data []byte) error {
+	root, err := DecodeBinary(bytes.NewReader(data), defaultCodec[T]())
+	if err != nil {
+		return err
+	}
+	n.Id, n.Data, n.Children = root.Id, root.Data, root.Children
+	return nil
+}
+
+// Writes tree to w in binary format.
+// The stream starts with a "GTRB" header and format version, followed by nodes in pre-order,
+// each as varint prefixed Id, varint child count and varint prefixed payload.
+// It ends with a CRC32 checksum of everything before it.
+func EncodeBinary[T any](w io.Writer, root *Node[T], codec PayloadCodec[T]) error {
+	if root == nil {
+		return errors.New("cannot encode nil tree")
+	}
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	bw.Write(binaryMagic)
+	bw.WriteByte(binaryVersion)
+
+	stack := []*Node[T]{root}
+	var scratch [binary.MaxVarintLen64]byte
+	writeBytes := func(b []byte) {
+		bw.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(b)))])
+		bw.Write(b)
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		payload, err := codec.Encode(node.Data)
+		if err != nil {
+			return fmt.Errorf("encoding node %q: %w", node.Id, err)
+		}
+		writeBytes([]byte(node.Id))
+		bw.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(node.Children)))])
+		writeBytes(payload)
+
+		for i := len(node.Children) - 1; i >= 0; i-- {
+			stack = append(stack, node.Children[i])
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// Reads a tree written by EncodeBinary() from r.
+// Nothing past the end of the tree is read, so r may hold more trees or other data after it.
+// Readers not implementing io.ByteReader are read one byte at a time, wrap them in a bufio.Reader to avoid it.
+// Returns io.EOF only if r ends before the tree starts, and io.ErrUnexpectedEOF if it ends inside the tree.
+// Returns an error if the header, version or checksum doesn't match.
+func DecodeBinary[T any](r io.Reader, codec PayloadCodec[T]) (*Node[T], error) {
+	crc := crc32.NewIEEE()
+	br := &checksumReader{r: newExactReader(r), crc: crc}
+
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(binaryMagic)], binaryMagic) {
+		return nil, errors.New("invalid binary tree header")
+	}
+	if header[len(binaryMagic)] != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary tree version %d", header[len(binaryMagic)])
+	}
+
+	readNode := func() (*Node[T], int, error) {
+		id, err := readBytes(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		count, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		payload, err := readBytes(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := codec.Decode(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decoding node %q: %w", id, err)
+		}
+		return &Node[T]{Id: string(id), Data: data}, int(count), nil
+	}
+
+	// stack of nodes still expecting children
+	type pending struct {
+		node      *Node[T]
+		remaining int
+	}
+	root, count, err := readNode()
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	stack := []pending{{root, count}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.remaining == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		top.remaining--
+
+		node, count, err := readNode()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		top.node.Children = append(top.node.Children, node)
+		stack = append(stack, pending{node, count})
+	}
+
+	expect := crc.Sum32()
+	var sum uint32
+	if err := binary.Read(br.r, binary.BigEndian, &sum); err != nil {
+		return nil, noEOF(err)
+	}
+	if sum != expect {
+		return nil, errors.New("binary tree checksum mismatch")
+	}
+
+	return root, nil
+}
+
+// Converts io.EOF to io.ErrUnexpectedEOF, for reads that started a tree.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Reads a varint prefixed byte slice.
+func readBytes(r *checksumReader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > math.MaxInt32 {
+		return nil, fmt.Errorf("field size %d too large", size)
+	}
+
+	// grow with data actually read, a corrupted size must not allocate upfront
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Reader computing checksum of everything read through it.
+type checksumReader struct {
+	r   exactReader
+	crc hash.Hash32
+}
+
+// Reader that can read a single byte, used to read varints without reading ahead.
+type exactReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Returns r if it can read single bytes, or wraps it to read them one at a time.
+func newExactReader(r io.Reader) exactReader {
+	if er, ok := r.(exactReader); ok {
+		return er
+	}
+	return &byteAtATimeReader{r: r}
+}
+
+// Reads single bytes from a reader without buffering.
+type byteAtATimeReader struct {
+	r io.Reader
+	b [1]byte
+}
+
+// Reads into p.
+func (b *byteAtATimeReader) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+// Reads a single byte.
+func (b *byteAtATimeReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(b.r, b.b[:]); err != nil {
+		return 0, err
+	}
+	return b.b[0], nil
+}
+
+// Reads into p and updates checksum.
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	return n, err
+}
+
+// Reads a single byte and updates checksum.
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+	}
+	return b, err
+}
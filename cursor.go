@@ -0,0 +1,214 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"errors"
+
+	"golang.org/x/exp/slices"
+)
+
+// Navigator holding the path from the root to its current node, so it can move
+// to parents and siblings without searching the tree.
+// Movement methods report whether the cursor moved, a failed move leaves the cursor in place.
+type Cursor[T any] struct {
+	path    []*Node[T] // root to current node
+	indexes []int      // index of each path node among its parent children, -1 for root
+}
+
+// Returns a cursor positioned at the object node, which is considered root node.
+func (n *Node[T]) Cursor() *Cursor[T] {
+	return &Cursor[T]{path: []*Node[T]{n}, indexes: []int{-1}}
+}
+
+// Returns a cursor positioned at target, object node is considered root node.
+// Returns ErrNodeNotFound if target is not inside the tree.
+func (n *Node[T]) CursorAt(target *Node[T]) (*Cursor[T], error) {
+	path := n.PathToNode(target)
+	if path == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	c := &Cursor[T]{path: path, indexes: make([]int, len(path))}
+	c.indexes[0] = -1
+	for i := 1; i < len(path); i++ {
+		c.indexes[i] = slices.Index(path[i-1].Children, path[i])
+	}
+	return c, nil
+}
+
+// Returns the current node.
+func (c *Cursor[T]) Node() *Node[T] {
+	return c.path[len(c.path)-1]
+}
+
+// Returns depth of the current node, root is at depth 0.
+func (c *Cursor[T]) Depth() int {
+	return len(c.path) - 1
+}
+
+// Returns index of the current node among its siblings, or -1 at root.
+func (c *Cursor[T]) Index() int {
+	return c.index(len(c.path) - 1)
+}
+
+// Returns a copy of the path from root to the current node.
+func (c *Cursor[T]) Path() []*Node[T] {
+	return slices.Clone(c.path)
+}
+
+// Returns an independent copy of the cursor.
+func (c *Cursor[T]) Clone() *Cursor[T] {
+	return &Cursor[T]{path: slices.Clone(c.path), indexes: slices.Clone(c.indexes)}
+}
+
+// Moves to the root.
+func (c *Cursor[T]) Root() {
+	c.path = c.path[:1]
+	c.indexes = c.indexes[:1]
+}
+
+// Moves to the parent, fails at root.
+func (c *Cursor[T]) Parent() bool {
+	if len(c.path) == 1 {
+		return false
+	}
+	c.path = c.path[:len(c.path)-1]
+	c.indexes = c.indexes[:len(c.indexes)-1]
+	return true
+}
+
+// Moves to the child at index i.
+func (c *Cursor[T]) Child(i int) bool {
+	children := c.Node().Children
+	if i < 0 || i >= len(children) {
+		return false
+	}
+	c.path = append(c.path, children[i])
+	c.indexes = append(c.indexes, i)
+	return true
+}
+
+// Moves to the first child, fails at leaves.
+func (c *Cursor[T]) FirstChild() bool {
+	return c.Child(0)
+}
+
+// Moves to the last child, fails at leaves.
+func (c *Cursor[T]) LastChild() bool {
+	return c.Child(len(c.Node().Children) - 1)
+}
+
+// Moves to the next sibling, fails at root and last children.
+func (c *Cursor[T]) NextSibling() bool {
+	return c.sibling(1)
+}
+
+// Moves to the previous sibling, fails at root and first children.
+func (c *Cursor[T]) PrevSibling() bool {
+	return c.sibling(-1)
+}
+
+// Inserts node as the previous sibling of the current node, the cursor stays on the current node.
+// If the parent is kept sorted, node is inserted in its sorted position instead.
+func (c *Cursor[T]) InsertBefore(node *Node[T]) error {
+	if len(c.path) == 1 {
+		return errors.New("cannot insert sibling of root node")
+	}
+	c.insert(node, c.Index())
+	return nil
+}
+
+// Inserts node as the next sibling of the current node, the cursor stays on the current node.
+// If the parent is kept sorted, node is inserted in its sorted position instead.
+func (c *Cursor[T]) InsertAfter(node *Node[T]) error {
+	if len(c.path) == 1 {
+		return errors.New("cannot insert sibling of root node")
+	}
+	c.insert(node, c.Index()+1)
+	return nil
+}
+
+// Appends node to the current node children, the cursor stays on the current node.
+func (c *Cursor[T]) AppendChild(node *Node[T]) {
+	insertChild(c.Node(), node, -1)
+}
+
+// Replaces the current node with node at the same position and moves the cursor to it.
+// Returns ErrNodeNotFound if the current node was removed from the tree outside the cursor.
+func (c *Cursor[T]) Replace(node *Node[T]) error {
+	if len(c.path) == 1 {
+		return errors.New("cannot replace root node")
+	}
+
+	last := len(c.path) - 1
+	parent := c.path[last-1]
+	index := removeChild(parent, c.path[last])
+	if index == -1 {
+		return ErrNodeNotFound
+	}
+	c.path[last] = node
+	c.indexes[last] = insertChild(parent, node, index)
+	return nil
+}
+
+// Removes the current node and moves the cursor to its next sibling,
+// or its previous sibling if it was the last child, or its parent if it was the only child.
+func (c *Cursor[T]) Remove() error {
+	if len(c.path) == 1 {
+		return errors.New("cannot remove root node")
+	}
+
+	index := c.Index()
+	if index == -1 {
+		return ErrNodeNotFound
+	}
+	c.Parent()
+	parent := c.Node()
+	removeChild(parent, parent.Children[index])
+
+	switch {
+	case index < len(parent.Children):
+		c.Child(index)
+	case index > 0:
+		c.Child(index - 1)
+	}
+	return nil
+}
+
+// Returns index of path node i among its parent children.
+// Cached indexes are checked, since the tree may have changed outside the cursor.
+func (c *Cursor[T]) index(i int) int {
+	if i == 0 {
+		return -1
+	}
+
+	children := c.path[i-1].Children
+	if j := c.indexes[i]; j < 0 || j >= len(children) || children[j] != c.path[i] {
+		c.indexes[i] = slices.Index(children, c.path[i])
+	}
+	return c.indexes[i]
+}
+
+// Moves to the sibling at offset from the current node.
+func (c *Cursor[T]) sibling(offset int) bool {
+	last := len(c.path) - 1
+	if last == 0 {
+		return false
+	}
+
+	siblings := c.path[last-1].Children
+	i := c.index(last) + offset
+	if i < 0 || i >= len(siblings) {
+		return false
+	}
+	c.path[last] = siblings[i]
+	c.indexes[last] = i
+	return true
+}
+
+// Inserts node under the current node parent at index.
+func (c *Cursor[T]) insert(node *Node[T], index int) {
+	insertChild(c.path[len(c.path)-2], node, index)
+}
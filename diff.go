@@ -0,0 +1,193 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Kind of a tree operation.
+type OpKind int
+
+const (
+	OpInsert  OpKind = iota + 1 // Insert a new node under Parent at Index
+	OpDelete                    // Delete node and its subtree
+	OpUpdate                    // Replace node data
+	OpMove                      // Move node from FromParent/FromIndex to Parent/Index
+	OpReorder                   // Reorder children of Parent as listed in Order, fails if Parent is kept sorted
+)
+
+// Returns operation name.
+func (k OpKind) String() string {
+	switch k {
+	case OpInsert:
+		return "insert"
+	case OpDelete:
+		return "delete"
+	case OpUpdate:
+		return "update"
+	case OpMove:
+		return "move"
+	case OpReorder:
+		return "reorder"
+	}
+	return fmt.Sprintf("OpKind(%d)", int(k))
+}
+
+// A single tree operation, nodes are addressed by their Id.
+// Fields that don't apply to the operation kind are left empty.
+type Op[T any] struct {
+	Kind       OpKind
+	Id         string   // Affected node, empty for OpReorder
+	Parent     string   // OpInsert, OpMove: target parent. OpReorder: parent whose children are reordered
+	Index      int      // OpInsert, OpMove: target position among parent children
+	FromParent string   // OpMove, OpDelete: previous parent
+	FromIndex  int      // OpMove, OpDelete: previous position among parent children
+	Old        T        // OpUpdate, OpDelete: previous data
+	New        T        // OpInsert, OpUpdate: new data
+	Order      []string // OpReorder: children Ids in their new order
+}
+
+// Returns a short human readable description of the operation, useful for audit logs.
+func (op Op[T]) String() string {
+	switch op.Kind {
+	case OpInsert:
+		return fmt.Sprintf("insert %s under %s at %d: %v", op.Id, op.Parent, op.Index, op.New)
+	case OpDelete:
+		return fmt.Sprintf("delete %s from %s at %d", op.Id, op.FromParent, op.FromIndex)
+	case OpUpdate:
+		return fmt.Sprintf("update %s: %v -> %v", op.Id, op.Old, op.New)
+	case OpMove:
+		return fmt.Sprintf("move %s from %s at %d to %s at %d", op.Id, op.FromParent, op.FromIndex, op.Parent, op.Index)
+	case OpReorder:
+		return fmt.Sprintf("reorder %s: %s", op.Parent, strings.Join(op.Order, ","))
+	}
+	return op.Kind.String()
+}
+
+// Diff compares two versions of a tree and returns the operations turning old into new.
+// Nodes are matched by Id, so Ids must be unique within each tree and both roots must share the same Id.
+// Data is compared using eq, if nil reflect.DeepEqual is used.
+//
+// Operations are ordered so they can be applied one after another:
+// inserts and moves (parents first), deletes (leaves first), updates, and finally reorders.
+func Diff[T any](old, new *Node[T], eq EqualFunc[T]) ([]Op[T], error) {
+	if old == nil || new == nil {
+		return nil, errors.New("cannot diff nil tree")
+	}
+	if old.Id != new.Id {
+		return nil, fmt.Errorf("root Ids differ: %q != %q", old.Id, new.Id)
+	}
+	if eq == nil {
+		eq = func(x, y T) bool {
+			return reflect.DeepEqual(x, y)
+		}
+	}
+
+	oldIds, err := indexIds(old)
+	if err != nil {
+		return nil, err
+	}
+	newIds, err := indexIds(new)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]Op[T], 0)
+	s := newShape(old)
+
+	// inserts and moves, parents are visited before their children
+	walkPreOrder(new, func(node, parent *Node[T], index int) {
+		if parent == nil {
+			return
+		}
+		if _, ok := oldIds[node.Id]; !ok {
+			ops = append(ops, Op[T]{Kind: OpInsert, Id: node.Id, Parent: parent.Id, Index: index, New: node.Data})
+			s.insert(node.Id, parent.Id, index)
+			return
+		}
+		if from := s.parent[node.Id]; from != parent.Id {
+			fromIndex := s.remove(node.Id)
+			ops = append(ops, Op[T]{Kind: OpMove, Id: node.Id, Parent: parent.Id, Index: index, FromParent: from, FromIndex: fromIndex})
+			s.insert(node.Id, parent.Id, index)
+		}
+	})
+
+	// deletes, surviving children are already moved away so every deleted node is a leaf by now
+	walkPostOrder(old, func(node *Node[T]) {
+		if _, ok := newIds[node.Id]; ok {
+			return
+		}
+		from := s.parent[node.Id]
+		fromIndex := s.remove(node.Id)
+		ops = append(ops, Op[T]{Kind: OpDelete, Id: node.Id, FromParent: from, FromIndex: fromIndex, Old: node.Data})
+	})
+
+	// updates
+	walkPreOrder(old, func(node, _ *Node[T], _ int) {
+		if n, ok := newIds[node.Id]; ok && !eq(node.Data, n.Data) {
+			ops = append(ops, Op[T]{Kind: OpUpdate, Id: node.Id, Old: node.Data, New: n.Data})
+		}
+	})
+
+	// reorders
+	walkPreOrder(new, func(node, _ *Node[T], _ int) {
+		order := make([]string, len(node.Children))
+		for i, child := range node.Children {
+			order[i] = child.Id
+		}
+		if !slices.Equal(s.children[node.Id], order) {
+			ops = append(ops, Op[T]{Kind: OpReorder, Parent: node.Id, Order: order})
+			s.children[node.Id] = order
+		}
+	})
+
+	return ops, nil
+}
+
+// Lightweight copy of a tree structure by Id, used to track positions while generating operations.
+type shape struct {
+	parent   map[string]string
+	children map[string][]string
+}
+
+// Builds tree shape from root.
+func newShape[T any](root *Node[T]) *shape {
+	s := &shape{
+		parent:   make(map[string]string),
+		children: make(map[string][]string),
+	}
+	walkPreOrder(root, func(node, parent *Node[T], _ int) {
+		if parent != nil {
+			s.parent[node.Id] = parent.Id
+			s.children[parent.Id] = append(s.children[parent.Id], node.Id)
+		}
+	})
+	return s
+}
+
+// Inserts id under parent at index, index is clamped to children length.
+func (s *shape) insert(id, parent string, index int) {
+	children := s.children[parent]
+	if index < 0 || index > len(children) {
+		index = len(children)
+	}
+	s.children[parent] = slices.Insert(children, index, id)
+	s.parent[id] = parent
+}
+
+// Removes id from its parent and returns its previous index.
+func (s *shape) remove(id string) int {
+	parent := s.parent[id]
+	children := s.children[parent]
+	index := slices.Index(children, id)
+	s.children[parent] = slices.Delete(children, index, index+1)
+	delete(s.parent, id)
+	return index
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Options used when comparing trees.
+type EqualOptions struct {
+	// Compare children regardless of their order.
+	// Data comparison function is expected to be an equivalence relation.
+	IgnoreOrder bool
+
+	// Compare node Ids in addition to node data.
+	CompareId bool
+}
+
+// Describes the first difference found while comparing two trees.
+type Mismatch[T any] struct {
+	Path   []*Node[T] // Nodes from the root of the first tree to the differing node
+	A      *Node[T]   // Differing node in the first tree, nil if missing
+	B      *Node[T]   // Differing node in the second tree, nil if missing
+	Reason string
+}
+
+// Returns the mismatch as Id path followed by the reason, e.g. "0/2/4: data differs".
+func (m *Mismatch[T]) String() string {
+	ids := make([]string, len(m.Path))
+	for i, node := range m.Path {
+		ids[i] = node.Id
+	}
+	return strings.Join(ids, "/") + ": " + m.Reason
+}
+
+// Compares two trees structurally, using eq to compare node data.
+// If eq is nil, only the structure (and Ids if requested) is compared.
+// Returns false and the first difference if trees are not equal.
+func Equal[T any](a, b *Node[T], eq EqualFunc[T], opts EqualOptions) (bool, *Mismatch[T]) {
+	if a == nil || b == nil {
+		if a == b {
+			return true, nil
+		}
+		return false, &Mismatch[T]{A: a, B: b, Reason: "missing tree"}
+	}
+
+	m := equalNode(a, b, eq, opts, []*Node[T]{})
+	return m == nil, m
+}
+
+// Checks if object node contains a subtree equal to sub.
+// Object node is considered root node, and is included in the search.
+func (n *Node[T]) Contains(sub *Node[T], eq EqualFunc[T], opts EqualOptions) bool {
+	if sub == nil {
+		return false
+	}
+
+	found := n.FindDFS(sub, func(node *Node[T], _ interface{}) bool {
+		return equalNode(node, sub, eq, opts, nil) == nil
+	})
+	return found != nil
+}
+
+// Find all nodes whose subtree is equal to pattern, using Depth First Search (DFS) algorithm.
+// Object node is considered root node, and is included in the search.
+func (n *Node[T]) FindSubtree(pattern *Node[T], eq EqualFunc[T], opts EqualOptions) []*Node[T] {
+	matches := make([]*Node[T], 0)
+	if pattern == nil {
+		return matches
+	}
+
+	return findSubtrees(n, pattern, eq, opts, matches)
+}
+
+// Recursive helper comparing two nodes and their children.
+// Returns nil when both subtrees are equal, otherwise the first mismatch.
+func equalNode[T any](a, b *Node[T], eq EqualFunc[T], opts EqualOptions, path []*Node[T]) *Mismatch[T] {
+	path = append(path, a)
+	if opts.CompareId && a.Id != b.Id {
+		return newMismatch(path, a, b, fmt.Sprintf("Id differs: %q != %q", a.Id, b.Id))
+	}
+	if eq != nil && !eq(a.Data, b.Data) {
+		return newMismatch(path, a, b, "data differs")
+	}
+	if len(a.Children) != len(b.Children) {
+		return newMismatch(path, a, b, fmt.Sprintf("children count differs: %d != %d", len(a.Children), len(b.Children)))
+	}
+
+	if !opts.IgnoreOrder {
+		for i, child := range a.Children {
+			if m := equalNode(child, b.Children[i], eq, opts, path); m != nil {
+				return m
+			}
+		}
+		return nil
+	}
+
+	// match every child in a with an unused equal child in b
+	used := make([]bool, len(b.Children))
+	for _, child := range a.Children {
+		matched := false
+		for j, other := range b.Children {
+			if !used[j] && equalNode(child, other, eq, opts, nil) == nil {
+				used[j] = true
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			// report the difference inside a child that looks alike, if any
+			for j, other := range b.Children {
+				if !used[j] && (!opts.CompareId || child.Id == other.Id) && (eq == nil || eq(child.Data, other.Data)) {
+					return equalNode(child, other, eq, opts, path)
+				}
+			}
+			return newMismatch(append(path, child), child, nil, "no matching child")
+		}
+	}
+
+	return nil
+}
+
+// Creates a mismatch with its own copy of the path.
+func newMismatch[T any](path []*Node[T], a, b *Node[T], reason string) *Mismatch[T] {
+	return &Mismatch[T]{
+		Path:   slices.Clone(path),
+		A:      a,
+		B:      b,
+		Reason: reason,
+	}
+}
+
+// Recursive helper collecting all subtrees equal to pattern.
+func findSubtrees[T any](node, pattern *Node[T], eq EqualFunc[T], opts EqualOptions, matches []*Node[T]) []*Node[T] {
+	if equalNode(node, pattern, eq, opts, nil) == nil {
+		matches = append(matches, node)
+	}
+
+	for _, child := range node.Children {
+		matches = findSubtrees(child, pattern, eq, opts, matches)
+	}
+
+	return matches
+}
@@ -0,0 +1,220 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// FromFS builds a tree mirroring the directory tree of fsys starting at root, in fs.WalkDir() order.
+// Node Ids are set to slash separated paths as passed to fn, which returns data for each file or directory.
+// Returning fs.SkipDir from fn skips a directory, any other error stops the build.
+func FromFS[T any](fsys fs.FS, root string, fn func(path string, d fs.DirEntry) (T, error)) (*Node[T], error) {
+	nodes := make(map[string]*Node[T])
+	var rootNode *Node[T]
+
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		data, err := fn(p, d)
+		if err != nil {
+			return err
+		}
+
+		node := &Node[T]{Id: p, Data: data}
+		nodes[p] = node
+		if p == root {
+			rootNode = node
+			return nil
+		}
+		parent := nodes[path.Dir(p)]
+		parent.Children = append(parent.Children, node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rootNode, nil
+}
+
+// Read only fs.FS view of a tree, so it can be used with fs.WalkDir, http.FS or template.ParseFS.
+// Root node is the "." directory, nodes with children are directories and leaves are files, unless IsDir is set.
+// Names must be valid path elements and unique among siblings, otherwise the first match wins.
+type TreeFS[T any] struct {
+	Root    *Node[T]
+	Name    func(n *Node[T]) string          // File name of a node
+	Content func(n *Node[T]) ([]byte, error) // File content of a node, nil means empty files
+	IsDir   func(n *Node[T]) bool            // Optional, reports directories, by default nodes with children
+}
+
+// Builds a read only fs.FS view of a tree, using name and content functions.
+func NewFS[T any](root *Node[T], name func(*Node[T]) string, content func(*Node[T]) ([]byte, error)) *TreeFS[T] {
+	return &TreeFS[T]{Root: root, Name: name, Content: content}
+}
+
+// Opens the named file or directory, implementing fs.FS.
+func (f *TreeFS[T]) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	node := f.Root
+	if name != "." {
+		for _, seg := range strings.Split(name, "/") {
+			if !f.isDir(node) {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			node = f.child(node, seg)
+			if node == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+		}
+	}
+
+	info, content, err := f.stat(node, path.Base(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !info.IsDir() {
+		return &treeFile{info: info, r: bytes.NewReader(content)}, nil
+	}
+
+	// file content is only fetched by Info(), so listing a directory doesn't read its files
+	entries := make([]fs.DirEntry, 0, len(node.Children))
+	for _, child := range node.Children {
+		entries = append(entries, &treeDirEntry[T]{fs: f, node: child, name: f.Name(child)})
+	}
+	return &treeDir{info: info, entries: entries, path: name}, nil
+}
+
+// Checks if node is a directory.
+func (f *TreeFS[T]) isDir(node *Node[T]) bool {
+	if f.IsDir != nil {
+		return f.IsDir(node)
+	}
+	return len(node.Children) > 0
+}
+
+// Returns child with name.
+func (f *TreeFS[T]) child(node *Node[T], name string) *Node[T] {
+	for _, child := range node.Children {
+		if f.Name(child) == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// Returns file info and content of a node.
+func (f *TreeFS[T]) stat(node *Node[T], name string) (*treeFileInfo, []byte, error) {
+	if f.isDir(node) {
+		return &treeFileInfo{name: name, mode: fs.ModeDir | 0o555}, nil, nil
+	}
+
+	var content []byte
+	if f.Content != nil {
+		var err error
+		if content, err = f.Content(node); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &treeFileInfo{name: name, size: int64(len(content)), mode: 0o444}, content, nil
+}
+
+// File info of a tree node, implementing fs.FileInfo.
+type treeFileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i *treeFileInfo) Name() string       { return i.name }
+func (i *treeFileInfo) Size() int64        { return i.size }
+func (i *treeFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *treeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i *treeFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *treeFileInfo) Sys() any           { return nil }
+
+// Directory entry of a tree node, implementing fs.DirEntry.
+type treeDirEntry[T any] struct {
+	fs   *TreeFS[T]
+	node *Node[T]
+	name string
+}
+
+func (e *treeDirEntry[T]) Name() string { return e.name }
+func (e *treeDirEntry[T]) IsDir() bool  { return e.fs.isDir(e.node) }
+
+// Returns the type bits of the entry.
+func (e *treeDirEntry[T]) Type() fs.FileMode {
+	if e.IsDir() {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+// Returns file info of the entry, fetching the content of files to report their size.
+func (e *treeDirEntry[T]) Info() (fs.FileInfo, error) {
+	info, _, err := e.fs.stat(e.node, e.name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Open file of a tree node.
+type treeFile struct {
+	info *treeFileInfo
+	r    *bytes.Reader
+}
+
+func (f *treeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *treeFile) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *treeFile) Close() error               { return nil }
+
+// Seeks in file content, so files can be served by http.FileServer.
+func (f *treeFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+// Open directory of a tree node, implementing fs.ReadDirFile.
+type treeDir struct {
+	info    *treeFileInfo
+	entries []fs.DirEntry
+	offset  int
+	path    string
+}
+
+func (d *treeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *treeDir) Close() error               { return nil }
+
+// Reading a directory is an error.
+func (d *treeDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: fs.ErrInvalid}
+}
+
+// Returns the next n directory entries, or all remaining entries if n <= 0.
+func (d *treeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := len(d.entries) - d.offset
+	if n <= 0 {
+		n = remaining
+	} else if remaining == 0 {
+		return nil, io.EOF
+	}
+	if n > remaining {
+		n = remaining
+	}
+
+	entries := d.entries[d.offset : d.offset+n]
+	d.offset += n
+	return entries, nil
+}
@@ -15,6 +15,13 @@ func Tree[T any]() *Node[T] {
 	return &root
 }
 
+// Builds empty tree that keeps its children sorted.
+// Nodes added with AddNode() are inserted in their sorted position according to comparison function.
+func SortedTree[T any](cmp SortFunc[T]) *Node[T] {
+	root := Node[T]{order: cmp}
+	return &root
+}
+
 // Build a tree out from slice of objects using comparison function to determine parent/child relationship.
 // Implement your own logic in compareFunc to specify parent/child relationship
 func Build[T any](values []T, compareFunc CompareFunc[T]) []*Node[T] {
@@ -59,3 +66,14 @@ func DeserializeJSONToTree[T any](jsonData string) (*Node[T], error) {
 	// Reconstruct the tree from the map.
 	return deserializeJSON[T](nodeMap), nil
 }
+
+// Resolve builds a new tree holding the effective value of every node.
+// Effective value of the root is its own data, each child's effective value is merge(parent effective value, child data).
+// Ids and structure are copied, the source tree is not modified.
+func Resolve[T any](root *Node[T], merge MergeFunc[T]) *Node[T] {
+	if root == nil {
+		return nil
+	}
+
+	return resolveNode[T](root, root.Data, merge)
+}
@@ -5,6 +5,11 @@ package gotrees
 
 import (
 	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
 )
 
 // helper used in recursive search for finding a leaves using DFS algorithm.
@@ -147,31 +152,34 @@ func size[T any](node *Node[T], currentSize *int) int {
 }
 
 // FindLowestCommonAncestor finds the lowest common ancestor of two nodes in a tree.
-func findLowestCommonAncestor[T any](root, p, q *Node[T]) *Node[T] {
+// Also returns how many of p and q were found under root, the ancestor is only valid when both were found.
+func findLowestCommonAncestor[T any](root, p, q *Node[T]) (*Node[T], int) {
 	if root == nil {
-		return nil
+		return nil, 0
 	}
 
-	// If the current node matches either p or q, it is the LCA.
-	if root == p || root == q {
-		return root
+	found := 0
+	if root == p {
+		found++
+	}
+	if root == q {
+		found++
 	}
 
 	// Recursively search for p and q in the children nodes.
-	var lca *Node[T]
 	for _, child := range root.Children {
-		childLCA := findLowestCommonAncestor(child, p, q)
-		if childLCA != nil {
-			if lca != nil {
-				// If a previous LCA was found, this node is the new LCA.
-				return root
-			}
-			lca = childLCA
+		lca, childFound := findLowestCommonAncestor(child, p, q)
+		if childFound == 2 {
+			// Both nodes are under this child.
+			return lca, 2
 		}
+		found += childFound
 	}
 
-	// Return the LCA found in the children (if any).
-	return lca
+	if found == 2 {
+		return root, 2
+	}
+	return nil, found
 }
 
 // Recursive function to serialize a node and its children.
@@ -307,3 +315,223 @@ func findFullByMem[T any](node *Node[T], parent *Node[T], depth int, target *Nod
 	}
 	return Details[T]{}
 }
+
+// Recursive helper for Resolve, copies node with its effective value and resolves children against it.
+func resolveNode[T any](node *Node[T], value T, merge MergeFunc[T]) *Node[T] {
+	resolved := &Node[T]{
+		Id:   node.Id,
+		Data: value,
+	}
+
+	if len(node.Children) > 0 {
+		resolved.Children = make([]*Node[T], len(node.Children))
+		for i, child := range node.Children {
+			resolved.Children[i] = resolveNode(child, merge(value, child.Data), merge)
+		}
+	}
+
+	return resolved
+}
+
+// Appends child to parent.
+// If parent is kept sorted, child inherits the comparison function and is inserted after all children that are not greater than it.
+func addChild[T any](parent *Node[T], child *Node[T]) {
+	if parent.order == nil {
+		parent.Children = append(parent.Children, child)
+		return
+	}
+
+	child.order = parent.order
+	idx := len(parent.Children)
+	for i, c := range parent.Children {
+		if parent.order(child, c) < 0 {
+			idx = i
+			break
+		}
+	}
+	parent.Children = slices.Insert(parent.Children, idx, child)
+}
+
+// Maps every node in the tree by its Id.
+// Returns ErrDuplicateId if an Id is repeated.
+func indexIds[T any](root *Node[T]) (map[string]*Node[T], error) {
+	ids := make(map[string]*Node[T])
+	var err error
+	walkPreOrder(root, func(node, _ *Node[T], _ int) {
+		if _, ok := ids[node.Id]; ok && err == nil {
+			err = fmt.Errorf("%w: %q", ErrDuplicateId, node.Id)
+		}
+		ids[node.Id] = node
+	})
+	return ids, err
+}
+
+// Visits every node in pre-order, passing its parent and index among parent children.
+// Parent is nil for the starting node.
+func walkPreOrder[T any](node *Node[T], visit func(node, parent *Node[T], index int)) {
+	visit(node, nil, 0)
+	walkChildren(node, visit)
+}
+
+// Recursive helper for walkPreOrder.
+func walkChildren[T any](parent *Node[T], visit func(node, parent *Node[T], index int)) {
+	for i, child := range parent.Children {
+		visit(child, parent, i)
+		walkChildren(child, visit)
+	}
+}
+
+// Visits every node in post-order, children before their parent.
+func walkPostOrder[T any](node *Node[T], visit func(node *Node[T])) {
+	for _, child := range node.Children {
+		walkPostOrder(child, visit)
+	}
+	visit(node)
+}
+
+// Inserts child under parent at index and returns its actual index.
+// Index out of range appends the child. If parent is kept sorted, index is ignored.
+func insertChild[T any](parent *Node[T], child *Node[T], index int) int {
+	index = placeChild(parent, child, index)
+	setJournal(child, parent.journal)
+	journalize(parent, func() { removeChild(parent, child) })
+	notifyAdded(parent, child, index)
+	return index
+}
+
+// Removes child from parent and returns its previous index, or -1 if not a child.
+func removeChild[T any](parent *Node[T], child *Node[T]) int {
+	index := unplaceChild(parent, child)
+	if index != -1 {
+		journalize(parent, func() { insertChild(parent, child, index) })
+		notifyRemoved(parent, child, index)
+	}
+	return index
+}
+
+// Moves child from one parent to another at index and returns its actual index.
+func moveChild[T any](from *Node[T], child *Node[T], to *Node[T], index int) int {
+	oldIndex := unplaceChild(from, child)
+	index = placeChild(to, child, index)
+	setJournal(child, to.journal)
+	journalize(to, func() { moveChild(to, child, from, oldIndex) })
+	notifyMoved(from, oldIndex, to, child, index)
+	return index
+}
+
+// Replaces children of node with the same children in another order, reporting the change.
+func reorderChild[T any](node *Node[T], children []*Node[T]) {
+	old := node.Children
+	node.Children = children
+	if !slices.Equal(old, children) {
+		journalize(node, func() { reorderChild(node, old) })
+		notifyReordered(node, old)
+	}
+}
+
+// Sorts children of node with a stable sort, reporting the change.
+func sortChildren[T any](node *Node[T], cmp SortFunc[T]) {
+	children := slices.Clone(node.Children)
+	slices.SortStableFunc(children, cmp)
+	reorderChild(node, children)
+}
+
+// Inserts child without reporting, see insertChild().
+func placeChild[T any](parent *Node[T], child *Node[T], index int) int {
+	if parent.order != nil {
+		addChild(parent, child)
+		return slices.Index(parent.Children, child)
+	}
+
+	if index < 0 || index > len(parent.Children) {
+		index = len(parent.Children)
+	}
+	parent.Children = slices.Insert(parent.Children, index, child)
+	return index
+}
+
+// Removes child without reporting, see removeChild().
+// Parent gets a new children slice, so slices taken before, e.g. by ranging over Children, are left untouched.
+func unplaceChild[T any](parent *Node[T], child *Node[T]) int {
+	index := slices.Index(parent.Children, child)
+	if index != -1 {
+		children := make([]*Node[T], 0, len(parent.Children)-1)
+		children = append(children, parent.Children[:index]...)
+		parent.Children = append(children, parent.Children[index+1:]...)
+	}
+	return index
+}
+
+// Returns parent of node and its index among parent children, using memory addresses.
+// Returns nil and -1 if node is the root or not inside the tree.
+func parentOf[T any](root *Node[T], node *Node[T]) (*Node[T], int) {
+	for i, child := range root.Children {
+		if child == node {
+			return root, i
+		}
+		if parent, index := parentOf(child, node); parent != nil {
+			return parent, index
+		}
+	}
+	return nil, -1
+}
+
+// Resolves Id path segments, first segment is the Id of root.
+// When siblings share an Id, the first match that resolves the rest of the path is returned.
+func resolveIdPath[T any](root *Node[T], segments []string) *Node[T] {
+	if len(segments) == 0 || root.Id != segments[0] {
+		return nil
+	}
+	if len(segments) == 1 {
+		return root
+	}
+
+	for _, child := range root.Children {
+		if found := resolveIdPath(child, segments[1:]); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Splits glob pattern into segments, validating each of them.
+func globSegments(pattern string) ([]string, error) {
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	for _, seg := range segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// Recursive helper for Glob, matches node against the first segment and its children against the rest.
+func globMatch[T any](node *Node[T], segments []string, found map[*Node[T]]bool) {
+	if len(segments) == 0 {
+		return
+	}
+
+	if segments[0] == "**" {
+		// zero segments
+		globMatch(node, segments[1:], found)
+		// one or more segments, node is consumed by **
+		if len(segments) == 1 {
+			found[node] = true
+		}
+		for _, child := range node.Children {
+			globMatch(child, segments, found)
+		}
+		return
+	}
+
+	if ok, _ := path.Match(segments[0], node.Id); !ok {
+		return
+	}
+	if len(segments) == 1 {
+		found[node] = true
+		return
+	}
+	for _, child := range node.Children {
+		globMatch(child, segments[1:], found)
+	}
+}
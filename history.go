@@ -0,0 +1,212 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"errors"
+
+	"golang.org/x/exp/slices"
+)
+
+// Reversible change, recorded by History.
+type command struct {
+	do   func()
+	undo func()
+}
+
+// Records mutations made through its methods as reversible commands, to support multi level undo and redo.
+// Mutations are made on the tree nodes directly, so node references stay valid across undo and redo.
+// Mutating the tree without History between calls may make recorded commands invalid.
+type History[T any] struct {
+	root  *Node[T]
+	limit int
+	undo  [][]command
+	redo  [][]command
+	group []command
+	marks []int // group length at each open Begin(), innermost last
+}
+
+// Builds history for tree starting at root.
+// Limit caps the number of undo steps kept, zero or less means unlimited.
+func NewHistory[T any](root *Node[T], limit int) *History[T] {
+	return &History[T]{root: root, limit: limit}
+}
+
+// Returns the tracked tree root.
+func (h *History[T]) Root() *Node[T] {
+	return h.root
+}
+
+// Adds node with data to parent and returns its memory reference.
+func (h *History[T]) AddNode(parent *Node[T], data T) *Node[T] {
+	node := parent.AddNode(data)
+	index := slices.Index(parent.Children, node)
+	h.record(command{
+		do:   func() { insertChild(parent, node, index) },
+		undo: func() { removeChild(parent, node) },
+	})
+	return node
+}
+
+// Deletes node with its subtree.
+func (h *History[T]) Delete(node *Node[T]) error {
+	if node == h.root {
+		return errors.New("cannot delete root node")
+	}
+	parent, index := parentOf(h.root, node)
+	if parent == nil {
+		return ErrNodeNotFound
+	}
+
+	removeChild(parent, node)
+	h.record(command{
+		do:   func() { removeChild(parent, node) },
+		undo: func() { insertChild(parent, node, index) },
+	})
+	return nil
+}
+
+// Moves node under parent at index, see Node.Move().
+func (h *History[T]) Move(node, parent *Node[T], index int) error {
+	oldParent, oldIndex := parentOf(h.root, node)
+	if err := h.root.Move(node, parent, index); err != nil {
+		return err
+	}
+
+	newIndex := slices.Index(parent.Children, node)
+	h.record(command{
+		do:   func() { moveChild(oldParent, node, parent, newIndex) },
+		undo: func() { moveChild(parent, node, oldParent, oldIndex) },
+	})
+	return nil
+}
+
+// Replaces node data.
+func (h *History[T]) SetData(node *Node[T], data T) {
+	old := node.Data
+	node.SetData(data)
+	h.record(command{
+		do:   func() { node.SetData(data) },
+		undo: func() { node.SetData(old) },
+	})
+}
+
+// Deletes all leaves and returns deleted nodes, recorded as a single undo step.
+// Root node is never trimmed.
+func (h *History[T]) TrimLeaves() []*Node[T] {
+	trimmed := make([]*Node[T], 0)
+	h.Begin()
+	for _, leaf := range h.root.Leaves() {
+		if leaf != h.root && h.Delete(leaf) == nil {
+			trimmed = append(trimmed, leaf)
+		}
+	}
+	h.Commit()
+	return trimmed
+}
+
+// Starts a transaction, commands recorded until Commit() are undone and redone as a single step.
+// Transactions can be nested, only the outermost Commit() records the step.
+func (h *History[T]) Begin() {
+	h.marks = append(h.marks, len(h.group))
+}
+
+// Ends a transaction started by Begin().
+func (h *History[T]) Commit() {
+	if len(h.marks) == 0 {
+		return
+	}
+	h.marks = h.marks[:len(h.marks)-1]
+	if len(h.marks) == 0 && len(h.group) > 0 {
+		h.push(h.group)
+		h.group = nil
+	}
+}
+
+// Reverts commands recorded since the innermost Begin() and ends that transaction.
+// Enclosing transactions stay open with their earlier commands.
+func (h *History[T]) Rollback() {
+	if len(h.marks) == 0 {
+		return
+	}
+	mark := h.marks[len(h.marks)-1]
+	h.marks = h.marks[:len(h.marks)-1]
+
+	for i := len(h.group) - 1; i >= mark; i-- {
+		h.group[i].undo()
+	}
+	h.group = h.group[:mark]
+	if len(h.marks) == 0 {
+		h.group = nil
+	}
+}
+
+// Runs fn in a transaction. If fn returns an error, its changes are reverted and the error is returned.
+func (h *History[T]) Transaction(fn func() error) error {
+	h.Begin()
+	if err := fn(); err != nil {
+		h.Rollback()
+		return err
+	}
+	h.Commit()
+	return nil
+}
+
+// Reverts the last step. Returns false if there is nothing to undo or a transaction is open.
+func (h *History[T]) Undo() bool {
+	if len(h.undo) == 0 || len(h.marks) > 0 {
+		return false
+	}
+
+	step := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	for i := len(step) - 1; i >= 0; i-- {
+		step[i].undo()
+	}
+	h.redo = append(h.redo, step)
+	return true
+}
+
+// Reapplies the last undone step. Returns false if there is nothing to redo or a transaction is open.
+func (h *History[T]) Redo() bool {
+	if len(h.redo) == 0 || len(h.marks) > 0 {
+		return false
+	}
+
+	step := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	for _, c := range step {
+		c.do()
+	}
+	h.undo = append(h.undo, step)
+	return true
+}
+
+// Checks if there is a step to undo.
+func (h *History[T]) CanUndo() bool {
+	return len(h.undo) > 0
+}
+
+// Checks if there is a step to redo.
+func (h *History[T]) CanRedo() bool {
+	return len(h.redo) > 0
+}
+
+// Records an applied command, into the open transaction if any.
+func (h *History[T]) record(c command) {
+	if len(h.marks) > 0 {
+		h.group = append(h.group, c)
+		return
+	}
+	h.push([]command{c})
+}
+
+// Pushes a step on the undo stack, dropping the redo stack and steps above the limit.
+func (h *History[T]) push(step []command) {
+	h.undo = append(h.undo, step)
+	h.redo = nil
+	if h.limit > 0 && len(h.undo) > h.limit {
+		h.undo = slices.Delete(h.undo, 0, len(h.undo)-h.limit)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Options used when comparing trees by shape and building canonical strings.
+type CanonicalOptions[T any] struct {
+	// Compare children regardless of their order.
+	IgnoreOrder bool
+
+	// Returns the label of node data, nodes match only if their labels are equal.
+	// When nil, only the structure is compared.
+	Label func(T) string
+
+	// Compare node Ids in addition to labels.
+	IncludeId bool
+}
+
+// Checks if two trees have the same shape using the AHU algorithm, which numbers every subtree
+// by its label and the numbers of its children, so equal numbers mean isomorphic subtrees.
+// Runs in linear time, plus sorting children numbers when order is ignored.
+func Isomorphic[T any](a, b *Node[T], opts CanonicalOptions[T]) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Size() != b.Size() {
+		return false
+	}
+
+	classes := make(map[string]int)
+	return ahuClass(a, opts, classes) == ahuClass(b, opts, classes)
+}
+
+// Returns a canonical string of the tree starting at node, e.g. `("Hany"("Mezo")("Hager"))`.
+// Two trees have the same string if and only if they are isomorphic under the same options,
+// so the string can be used as a map key to group trees by shape.
+func CanonicalString[T any](node *Node[T], opts CanonicalOptions[T]) string {
+	if node == nil {
+		return ""
+	}
+
+	var ranks map[*Node[T]]int
+	if opts.IgnoreOrder {
+		ranks = ahuRanks(node, opts)
+	}
+
+	var sb strings.Builder
+	writeCanonical(&sb, node, opts, ranks)
+	return sb.String()
+}
+
+// Returns the label of node, including its Id if requested.
+func ahuLabel[T any](node *Node[T], opts CanonicalOptions[T]) string {
+	label := ""
+	if opts.IncludeId {
+		label = strconv.Quote(node.Id)
+	}
+	if opts.Label != nil {
+		label += strconv.Quote(opts.Label(node.Data))
+	}
+	return label
+}
+
+// Returns AHU class of the subtree starting at node, classes are shared by the compared trees.
+func ahuClass[T any](node *Node[T], opts CanonicalOptions[T], classes map[string]int) int {
+	children := make([]int, len(node.Children))
+	for i, child := range node.Children {
+		children[i] = ahuClass(child, opts, classes)
+	}
+	if opts.IgnoreOrder {
+		slices.Sort(children)
+	}
+
+	key := []byte(ahuLabel(node, opts))
+	for _, child := range children {
+		key = append(key, ',')
+		key = strconv.AppendInt(key, int64(child), 10)
+	}
+
+	class, ok := classes[string(key)]
+	if !ok {
+		class = len(classes)
+		classes[string(key)] = class
+	}
+	return class
+}
+
+// Ranks subtrees level by level from the deepest, by label and then by the sorted ranks of their children.
+// Ranks of nodes on the same level follow one fixed order of shapes, so sorting siblings by rank
+// orders them the same way in every tree.
+func ahuRanks[T any](root *Node[T], opts CanonicalOptions[T]) map[*Node[T]]int {
+	levels := [][]*Node[T]{{root}}
+	for d := 0; len(levels[d]) > 0; d++ {
+		next := []*Node[T]{}
+		for _, node := range levels[d] {
+			next = append(next, node.Children...)
+		}
+		levels = append(levels, next)
+	}
+
+	type key struct {
+		node     *Node[T]
+		label    string
+		children []int
+	}
+	ranks := make(map[*Node[T]]int)
+	for d := len(levels) - 2; d >= 0; d-- {
+		keys := make([]key, len(levels[d]))
+		for i, node := range levels[d] {
+			children := make([]int, len(node.Children))
+			for j, child := range node.Children {
+				children[j] = ranks[child]
+			}
+			slices.Sort(children)
+			keys[i] = key{node: node, label: ahuLabel(node, opts), children: children}
+		}
+
+		compare := func(a, b key) int {
+			if c := strings.Compare(a.label, b.label); c != 0 {
+				return c
+			}
+			return slices.Compare(a.children, b.children)
+		}
+		slices.SortFunc(keys, compare)
+
+		rank := 0
+		for i, k := range keys {
+			if i > 0 && compare(keys[i-1], k) != 0 {
+				rank++
+			}
+			ranks[k.node] = rank
+		}
+	}
+	return ranks
+}
+
+// Writes canonical string of node, children are ordered by rank when order is ignored.
+func writeCanonical[T any](sb *strings.Builder, node *Node[T], opts CanonicalOptions[T], ranks map[*Node[T]]int) {
+	sb.WriteByte('(')
+	sb.WriteString(ahuLabel(node, opts))
+
+	children := node.Children
+	if ranks != nil {
+		children = slices.Clone(children)
+		slices.SortStableFunc(children, func(a, b *Node[T]) int {
+			return ranks[a] - ranks[b]
+		})
+	}
+	for _, child := range children {
+		writeCanonical(sb, child, opts, ranks)
+	}
+	sb.WriteByte(')')
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"errors"
+	"math/bits"
+
+	"golang.org/x/exp/slices"
+)
+
+// Preprocessed index answering ancestor queries on a fixed tree.
+// It is built once in O(n log n), then answers LCA, IsAncestor and Distance in O(1),
+// KthAncestor in O(log n) and PathN2N in O(path length).
+// The index does not follow later changes to the tree, build a new one after mutating it.
+type LCAIndex[T any] struct {
+	nodes  []*Node[T]       // nodes in pre-order
+	num    map[*Node[T]]int // pre-order number of each node
+	depth  []int
+	size   []int   // subtree sizes
+	up     [][]int // up[k][v] is the 2^k-th ancestor of v, root is its own ancestor
+	first  []int   // first occurrence of each node in the Euler tour
+	sparse [][]int // sparse[k][i] is the shallowest node of Euler tour range [i, i+2^k)
+}
+
+// Builds an index for the tree starting at root.
+func NewLCAIndex[T any](root *Node[T]) *LCAIndex[T] {
+	ix := &LCAIndex[T]{num: make(map[*Node[T]]int)}
+	parents := []int{}
+	euler := []int{}
+
+	// iterative pre-order walk, deep trees must not overflow the stack
+	type frame struct {
+		v, next int
+	}
+	ix.visit(root, 0)
+	parents = append(parents, 0)
+	euler = append(euler, 0)
+	stack := []frame{{v: 0}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		node := ix.nodes[top.v]
+		if top.next == len(node.Children) {
+			ix.size[top.v] = len(ix.nodes) - top.v
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				euler = append(euler, stack[len(stack)-1].v)
+			}
+			continue
+		}
+
+		child := node.Children[top.next]
+		top.next++
+		v := ix.visit(child, ix.depth[top.v]+1)
+		parents = append(parents, top.v)
+		euler = append(euler, v)
+		stack = append(stack, frame{v: v})
+	}
+
+	// binary lifting
+	levels := bits.Len(uint(len(ix.nodes)))
+	ix.up = make([][]int, levels)
+	ix.up[0] = parents
+	for k := 1; k < levels; k++ {
+		ix.up[k] = make([]int, len(ix.nodes))
+		for v := range ix.nodes {
+			ix.up[k][v] = ix.up[k-1][ix.up[k-1][v]]
+		}
+	}
+
+	// sparse table over the Euler tour
+	ix.first = make([]int, len(ix.nodes))
+	for i := len(euler) - 1; i >= 0; i-- {
+		ix.first[euler[i]] = i
+	}
+	ix.sparse = [][]int{euler}
+	for k := 1; 1<<k <= len(euler); k++ {
+		prev := ix.sparse[k-1]
+		row := make([]int, len(euler)-1<<k+1)
+		for i := range row {
+			row[i] = ix.shallower(prev[i], prev[i+1<<(k-1)])
+		}
+		ix.sparse = append(ix.sparse, row)
+	}
+
+	return ix
+}
+
+// Returns the lowest common ancestor of p and q, or false if any of them is not inside the tree.
+func (ix *LCAIndex[T]) LCA(p, q *Node[T]) (*Node[T], bool) {
+	u, ok1 := ix.num[p]
+	v, ok2 := ix.num[q]
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	return ix.nodes[ix.lca(u, v)], true
+}
+
+// Checks if a is a proper ancestor of b.
+// Returns ErrNodeNotFound if any of the nodes is not inside the tree.
+func (ix *LCAIndex[T]) IsAncestor(a, b *Node[T]) (bool, error) {
+	u, ok1 := ix.num[a]
+	v, ok2 := ix.num[b]
+	if !ok1 || !ok2 {
+		return false, ErrNodeNotFound
+	}
+	return u < v && v < u+ix.size[u], nil
+}
+
+// Returns depth of node, root is at depth 0.
+// Returns ErrNodeNotFound if node is not inside the tree.
+func (ix *LCAIndex[T]) Depth(node *Node[T]) (int, error) {
+	v, ok := ix.num[node]
+	if !ok {
+		return 0, ErrNodeNotFound
+	}
+	return ix.depth[v], nil
+}
+
+// Returns number of edges between a and b.
+// Returns ErrNodeNotFound if any of the nodes is not inside the tree.
+func (ix *LCAIndex[T]) Distance(a, b *Node[T]) (int, error) {
+	u, ok1 := ix.num[a]
+	v, ok2 := ix.num[b]
+	if !ok1 || !ok2 {
+		return 0, ErrNodeNotFound
+	}
+	return ix.depth[u] + ix.depth[v] - 2*ix.depth[ix.lca(u, v)], nil
+}
+
+// Returns the k-th ancestor of node, k = 0 is the node itself and k = 1 its parent.
+// Returns ErrNodeNotFound if node is not inside the tree, and an error if k is negative or above the node depth.
+func (ix *LCAIndex[T]) KthAncestor(node *Node[T], k int) (*Node[T], error) {
+	v, ok := ix.num[node]
+	if !ok {
+		return nil, ErrNodeNotFound
+	}
+	if k < 0 || k > ix.depth[v] {
+		return nil, errors.New("ancestor level out of range")
+	}
+	return ix.nodes[ix.ancestor(v, k)], nil
+}
+
+// Returns path from p to q through their lowest common ancestor, same as Node.PathN2N().
+// Returns ErrNodeNotFound if any of the nodes is not inside the tree.
+func (ix *LCAIndex[T]) PathN2N(p, q *Node[T]) ([]*Node[T], error) {
+	u, ok1 := ix.num[p]
+	v, ok2 := ix.num[q]
+	if !ok1 || !ok2 {
+		return nil, ErrNodeNotFound
+	}
+
+	lca := ix.lca(u, v)
+	path := make([]*Node[T], 0, ix.depth[u]+ix.depth[v]-2*ix.depth[lca]+1)
+	for ; u != lca; u = ix.up[0][u] {
+		path = append(path, ix.nodes[u])
+	}
+	path = append(path, ix.nodes[lca])
+
+	tail := len(path)
+	for ; v != lca; v = ix.up[0][v] {
+		path = append(path, ix.nodes[v])
+	}
+	slices.Reverse(path[tail:])
+
+	return path, nil
+}
+
+// Numbers node in pre-order.
+func (ix *LCAIndex[T]) visit(node *Node[T], depth int) int {
+	v := len(ix.nodes)
+	ix.nodes = append(ix.nodes, node)
+	ix.num[node] = v
+	ix.depth = append(ix.depth, depth)
+	ix.size = append(ix.size, 1)
+	return v
+}
+
+// Returns lowest common ancestor by pre-order numbers.
+func (ix *LCAIndex[T]) lca(u, v int) int {
+	l, r := ix.first[u], ix.first[v]
+	if l > r {
+		l, r = r, l
+	}
+	k := bits.Len(uint(r-l+1)) - 1
+	return ix.shallower(ix.sparse[k][l], ix.sparse[k][r-1<<k+1])
+}
+
+// Returns the k-th ancestor by pre-order number.
+func (ix *LCAIndex[T]) ancestor(v, k int) int {
+	for i := 0; k > 0; i, k = i+1, k>>1 {
+		if k&1 == 1 {
+			v = ix.up[i][v]
+		}
+	}
+	return v
+}
+
+// Returns the shallower of two nodes.
+func (ix *LCAIndex[T]) shallower(u, v int) int {
+	if ix.depth[u] <= ix.depth[v] {
+		return u
+	}
+	return v
+}
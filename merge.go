@@ -0,0 +1,462 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"golang.org/x/exp/slices"
+)
+
+// Kind of a merge conflict.
+type ConflictKind int
+
+const (
+	ConflictData       ConflictKind = iota + 1 // Both sides changed node data differently
+	ConflictMove                               // Both sides moved node to different parents
+	ConflictDeleteEdit                         // One side deleted a node the other side edited
+	ConflictInsert                             // Both sides inserted the same Id differently
+	ConflictCycle                              // Moves from both sides created a cycle, node was moved back
+)
+
+// Returns conflict kind name.
+func (k ConflictKind) String() string {
+	switch k {
+	case ConflictData:
+		return "data"
+	case ConflictMove:
+		return "move"
+	case ConflictDeleteEdit:
+		return "delete/edit"
+	case ConflictInsert:
+		return "insert"
+	case ConflictCycle:
+		return "cycle"
+	}
+	return fmt.Sprintf("ConflictKind(%d)", int(k))
+}
+
+// Side taken to resolve a conflict.
+type Resolution int
+
+const (
+	TakeOurs Resolution = iota
+	TakeTheirs
+	TakeBase
+)
+
+// Describes a conflict found by Merge3().
+// Base, Ours and Theirs are the node versions from each tree, nil where the node doesn't exist.
+type Conflict[T any] struct {
+	Kind       ConflictKind
+	Id         string
+	Base       *Node[T]
+	Ours       *Node[T]
+	Theirs     *Node[T]
+	Resolution Resolution
+}
+
+// Returns a short human readable description of the conflict.
+func (c Conflict[T]) String() string {
+	return fmt.Sprintf("%v conflict on %s", c.Kind, c.Id)
+}
+
+// Resolves a conflict by choosing a side.
+// Returning TakeBase for an insert conflict is the same as TakeOurs, as there is no base version.
+type ConflictFunc[T any] func(c Conflict[T]) Resolution
+
+// Merge3 merges two concurrently edited versions of base into a new tree.
+// Nodes are matched by Id, so Ids must be unique within each tree and all roots must share the same Id.
+// Data is compared using reflect.DeepEqual.
+//
+// Non overlapping edits from both sides are combined, e.g. a move on one side and a data change on the other.
+// Conflicting edits are passed to resolve, if nil ours wins. Deleting a node removes its subtree.
+// Cycles created by moves on both sides are broken by moving nodes back to their base parent.
+// Input trees are not modified, returns the merged tree and all conflicts found.
+func Merge3[T any](base, ours, theirs *Node[T], resolve ConflictFunc[T]) (*Node[T], []Conflict[T], error) {
+	if base == nil || ours == nil || theirs == nil {
+		return nil, nil, errors.New("cannot merge nil tree")
+	}
+	if base.Id != ours.Id || base.Id != theirs.Id {
+		return nil, nil, errors.New("root Ids differ")
+	}
+	if resolve == nil {
+		resolve = func(Conflict[T]) Resolution { return TakeOurs }
+	}
+
+	m := &merger[T]{
+		resolve: resolve,
+		merged:  make(map[string]*mergedNode[T]),
+		rootId:  base.Id,
+	}
+	for i, tree := range []*Node[T]{base, ours, theirs} {
+		v, err := newVersion(tree)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.versions[i] = v
+	}
+
+	// decide every node once, base nodes first, then inserted ones
+	for _, v := range m.versions {
+		for _, id := range v.order {
+			if _, done := m.merged[id]; !done {
+				m.mergeNode(id)
+			}
+		}
+	}
+	m.fixOrphans()
+
+	return m.build(m.rootId), m.conflicts, nil
+}
+
+// Version of a tree indexed by Id.
+type version[T any] struct {
+	nodes  map[string]*Node[T]
+	parent map[string]string
+	order  []string // Ids in pre-order
+}
+
+// Indexes tree by Id.
+func newVersion[T any](root *Node[T]) (*version[T], error) {
+	nodes, err := indexIds(root)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &version[T]{nodes: nodes, parent: make(map[string]string)}
+	walkPreOrder(root, func(node, parent *Node[T], _ int) {
+		if parent != nil {
+			v.parent[node.Id] = parent.Id
+		}
+		v.order = append(v.order, node.Id)
+	})
+	return v, nil
+}
+
+// Merge decision for a single node.
+type mergedNode[T any] struct {
+	present bool
+	data    T
+	parent  string
+	kept    bool // kept by a delete/edit resolution, its deleted ancestors are restored
+}
+
+// Merge state.
+type merger[T any] struct {
+	versions  [3]*version[T] // base, ours, theirs
+	resolve   ConflictFunc[T]
+	merged    map[string]*mergedNode[T]
+	conflicts []Conflict[T]
+	rootId    string
+}
+
+// Asks resolver and records the conflict.
+func (m *merger[T]) conflict(kind ConflictKind, id string) Resolution {
+	c := Conflict[T]{
+		Kind:   kind,
+		Id:     id,
+		Base:   m.versions[0].nodes[id],
+		Ours:   m.versions[1].nodes[id],
+		Theirs: m.versions[2].nodes[id],
+	}
+	c.Resolution = m.resolve(c)
+	m.conflicts = append(m.conflicts, c)
+	return c.Resolution
+}
+
+// Decides existence, data and parent of a node.
+func (m *merger[T]) mergeNode(id string) {
+	b, o, t := m.versions[0].nodes[id], m.versions[1].nodes[id], m.versions[2].nodes[id]
+	result := &mergedNode[T]{}
+	m.merged[id] = result
+
+	// take a side as is
+	take := func(side int) {
+		node := m.versions[side].nodes[id]
+		if node == nil {
+			return
+		}
+		result.present = true
+		result.data = node.Data
+		result.parent = m.versions[side].parent[id]
+	}
+
+	switch {
+	case b == nil && o != nil && t != nil:
+		// inserted on both sides
+		if reflect.DeepEqual(o.Data, t.Data) && m.versions[1].parent[id] == m.versions[2].parent[id] {
+			take(1)
+		} else if m.conflict(ConflictInsert, id) == TakeTheirs {
+			take(2)
+		} else {
+			take(1)
+		}
+
+	case b == nil && o != nil:
+		take(1)
+
+	case b == nil:
+		take(2)
+
+	case o == nil && t == nil:
+		// deleted on both sides
+
+	case o == nil || t == nil:
+		// deleted on one side, keep it only if the other side edited it
+		side := 1
+		if o == nil {
+			side = 2
+		}
+		if !m.edited(side, id) {
+			return
+		}
+		switch m.conflict(ConflictDeleteEdit, id) {
+		case TakeOurs:
+			take(1)
+		case TakeTheirs:
+			take(2)
+		case TakeBase:
+			take(0)
+		}
+		result.kept = result.present
+
+	default:
+		result.present = true
+		result.data = merge3(b.Data, o.Data, t.Data, func() Resolution {
+			return m.conflict(ConflictData, id)
+		})
+		result.parent = merge3(m.versions[0].parent[id], m.versions[1].parent[id], m.versions[2].parent[id], func() Resolution {
+			return m.conflict(ConflictMove, id)
+		})
+	}
+}
+
+// Checks if a side changed a node compared to base, by data, parent, by adding children under it,
+// or by editing any of its descendants.
+func (m *merger[T]) edited(side int, id string) bool {
+	b, s := m.versions[0].nodes[id], m.versions[side].nodes[id]
+	if !reflect.DeepEqual(b.Data, s.Data) || m.versions[0].parent[id] != m.versions[side].parent[id] {
+		return true
+	}
+	for _, child := range s.Children {
+		if m.versions[0].parent[child.Id] != id || m.edited(side, child.Id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Three way merge of a single value, conflict is called when both sides changed it differently.
+func merge3[V any](base, ours, theirs V, conflict func() Resolution) V {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(theirs, base):
+		return ours
+	case reflect.DeepEqual(ours, base):
+		return theirs
+	}
+
+	switch conflict() {
+	case TakeTheirs:
+		return theirs
+	case TakeBase:
+		return base
+	}
+	return ours
+}
+
+// Removes nodes whose parent was deleted, and breaks cycles by moving nodes back to their base parent.
+// Deleted ancestors of nodes kept by a delete/edit resolution are restored instead.
+func (m *merger[T]) fixOrphans() {
+	for {
+		reachable := map[string]bool{m.rootId: true}
+		var visit func(id string)
+		children := m.children()
+		visit = func(id string) {
+			for _, child := range children[id] {
+				reachable[child] = true
+				visit(child)
+			}
+		}
+		visit(m.rootId)
+
+		fixed := true
+		for _, id := range m.ids() {
+			if !m.merged[id].present || reachable[id] {
+				continue
+			}
+			fixed = false
+
+			// follow parents until a deleted parent or a cycle
+			seen := map[string]bool{}
+			cur := id
+			cycle := true
+			for !seen[cur] {
+				seen[cur] = true
+				parent, ok := m.merged[m.merged[cur].parent]
+				if ok && !parent.present && m.merged[id].kept {
+					// never drop a node a resolution kept, restore its deleted ancestor instead
+					m.restore(m.merged[cur].parent)
+					cycle = false
+					break
+				}
+				if !ok || !parent.present {
+					m.merged[id].present = false
+					cycle = false
+					break
+				}
+				cur = m.merged[cur].parent
+			}
+			if cycle {
+				m.breakCycle(cur)
+			}
+			break
+		}
+
+		if fixed {
+			return
+		}
+	}
+}
+
+// Restores a deleted node from base, so nodes kept below it stay in the tree.
+// The node is restored as kept, so its own deleted ancestors are restored as well.
+func (m *merger[T]) restore(id string) {
+	node := m.merged[id]
+	node.present = true
+	node.kept = true
+	node.data = m.versions[0].nodes[id].Data
+	node.parent = m.versions[0].parent[id]
+	m.conflicts = append(m.conflicts, Conflict[T]{
+		Kind:       ConflictDeleteEdit,
+		Id:         id,
+		Base:       m.versions[0].nodes[id],
+		Ours:       m.versions[1].nodes[id],
+		Theirs:     m.versions[2].nodes[id],
+		Resolution: TakeBase,
+	})
+}
+
+// Breaks the cycle containing id, by moving the first node found away from its base parent back to it.
+func (m *merger[T]) breakCycle(id string) {
+	cur := id
+	for {
+		node := m.merged[cur]
+		baseParent, ok := m.versions[0].parent[cur]
+		if p, exists := m.merged[baseParent]; !ok || !exists || !p.present {
+			baseParent = m.rootId
+		}
+		if node.parent != baseParent {
+			node.parent = baseParent
+			m.conflicts = append(m.conflicts, Conflict[T]{
+				Kind:       ConflictCycle,
+				Id:         cur,
+				Base:       m.versions[0].nodes[cur],
+				Ours:       m.versions[1].nodes[cur],
+				Theirs:     m.versions[2].nodes[cur],
+				Resolution: TakeBase,
+			})
+			return
+		}
+		cur = node.parent
+	}
+}
+
+// Returns merged Ids in a stable order.
+func (m *merger[T]) ids() []string {
+	ids := make([]string, 0, len(m.merged))
+	seen := make(map[string]bool, len(m.merged))
+	for _, v := range m.versions {
+		for _, id := range v.order {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// Returns merged children of every node, ordered by taking the side that reordered them.
+func (m *merger[T]) children() map[string][]string {
+	children := make(map[string][]string)
+	for _, id := range m.ids() {
+		if node := m.merged[id]; node.present && id != m.rootId {
+			children[node.parent] = append(children[node.parent], id)
+		}
+	}
+
+	for parent, ids := range children {
+		sides := [3][]string{}
+		for i, v := range m.versions {
+			if node, ok := v.nodes[parent]; ok {
+				for _, child := range node.Children {
+					if slices.Contains(ids, child.Id) {
+						sides[i] = append(sides[i], child.Id)
+					}
+				}
+			}
+		}
+
+		// ours wins unless only theirs changed the relative order
+		primary, secondary := sides[1], sides[2]
+		if sameOrder(sides[0], sides[1]) {
+			primary, secondary = sides[2], sides[1]
+		}
+
+		// nodes only placed by the secondary side follow their predecessor there
+		order := slices.Clone(primary)
+		for i, id := range secondary {
+			if slices.Contains(order, id) {
+				continue
+			}
+			at := 0
+			for j := i - 1; j >= 0; j-- {
+				if k := slices.Index(order, secondary[j]); k != -1 {
+					at = k + 1
+					break
+				}
+			}
+			order = slices.Insert(order, at, id)
+		}
+		for _, id := range append(sides[0], ids...) {
+			if !slices.Contains(order, id) {
+				order = append(order, id)
+			}
+		}
+		children[parent] = order
+	}
+
+	return children
+}
+
+// Checks if elements common to both slices appear in the same relative order.
+func sameOrder(a, b []string) bool {
+	common := func(s, other []string) []string {
+		result := make([]string, 0, len(s))
+		for _, id := range s {
+			if slices.Contains(other, id) {
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+	return slices.Equal(common(a, b), common(b, a))
+}
+
+// Builds the merged tree starting from id.
+func (m *merger[T]) build(id string) *Node[T] {
+	children := m.children()
+	var build func(id string) *Node[T]
+	build = func(id string) *Node[T] {
+		node := &Node[T]{Id: id, Data: m.merged[id].data}
+		for _, child := range children[id] {
+			node.Children = append(node.Children, build(child))
+		}
+		return node
+	}
+	return build(id)
+}
@@ -0,0 +1,189 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// Options for Merkle hashing.
+type HashOptions struct {
+	Unordered bool             // sort child hashes, so children order doesn't change the hash
+	IncludeId bool             // hash node Ids along with data
+	New       func() hash.Hash // hash function, sha256 when nil
+}
+
+// Returns the Merkle hash of object node: the hash of its data followed by the hashes of its children in order.
+// Function h encodes node data, e.g. with json.Marshal. Ids are not hashed and sha256 is used.
+// Equal hashes mean equal subtrees, use Merkle for other options and cached hashes.
+func (n *Node[T]) Hash(h func(T) []byte) []byte {
+	return merkleHash(n, h, HashOptions{}, make(map[*Node[T]][]byte), nil)
+}
+
+// Merkle hashes of a tree, cached per node.
+// Cached hashes are dropped when the tree is changed through package functions, see Subscribe().
+// Call Invalidate() after changing Data or Children directly.
+type Merkle[T any] struct {
+	mu     sync.Mutex
+	root   *Node[T]
+	data   func(T) []byte
+	opts   HashOptions
+	cache  map[*Node[T]][]byte
+	parent map[*Node[T]]*Node[T]
+	sub    *Subscription[T]
+}
+
+// Builds a hash cache for the tree starting at root, function data encodes node data.
+// Hashes are computed lazily. Call Close() to stop following changes.
+func NewMerkle[T any](root *Node[T], data func(T) []byte, opts HashOptions) *Merkle[T] {
+	m := &Merkle[T]{
+		root:   root,
+		data:   data,
+		opts:   opts,
+		cache:  make(map[*Node[T]][]byte),
+		parent: make(map[*Node[T]]*Node[T]),
+	}
+	m.sub = root.Subscribe(m.update)
+	return m
+}
+
+// Returns the hash of node, computing hashes missing from the cache.
+// The returned slice must not be modified.
+func (m *Merkle[T]) Hash(node *Node[T]) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return merkleHash(node, m.data, m.opts, m.cache, m.parent)
+}
+
+// Drops cached hashes of node and its ancestors.
+func (m *Merkle[T]) Invalidate(node *Node[T]) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invalidate(node)
+}
+
+// Returns groups of identical subtrees, each in Depth First Search (DFS) order, ordered by their first node.
+// Only groups of two or more subtrees are returned.
+func (m *Merkle[T]) Duplicates() [][]*Node[T] {
+	m.Hash(m.root)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	groups := make(map[string]int)
+	result := make([][]*Node[T], 0)
+	walkPreOrder(m.root, func(node, _ *Node[T], _ int) {
+		key := string(m.cache[node])
+		i, ok := groups[key]
+		if !ok {
+			i = len(result)
+			groups[key] = i
+			result = append(result, nil)
+		}
+		result[i] = append(result[i], node)
+	})
+
+	duplicates := result[:0]
+	for _, group := range result {
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// Stops following tree changes, cached hashes may be stale afterwards.
+func (m *Merkle[T]) Close() {
+	m.sub.Cancel()
+}
+
+// Drops cached hashes affected by a mutation.
+func (m *Merkle[T]) update(e Event[T]) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch e.Kind {
+	case NodeAdded:
+		m.parent[e.Node] = e.NewParent
+		m.invalidate(e.NewParent)
+	case NodeRemoved:
+		m.invalidate(e.OldParent)
+		m.forget(e.Node)
+	case NodeMoved:
+		m.invalidate(e.OldParent)
+		m.parent[e.Node] = e.NewParent
+		m.invalidate(e.NewParent)
+	case DataChanged, ChildrenReordered:
+		m.invalidate(e.Node)
+	}
+}
+
+// Drops cached hashes of node and its ancestors.
+// Ancestors of a node missing from the cache are missing too, so the walk stops there.
+func (m *Merkle[T]) invalidate(node *Node[T]) {
+	for node != nil {
+		if _, ok := m.cache[node]; !ok {
+			return
+		}
+		delete(m.cache, node)
+		node = m.parent[node]
+	}
+}
+
+// Drops everything known about a subtree that left the tree.
+func (m *Merkle[T]) forget(node *Node[T]) {
+	walkPreOrder(node, func(n, _ *Node[T], _ int) {
+		delete(m.cache, n)
+		delete(m.parent, n)
+	})
+}
+
+// Computes hash of node, reusing and filling cache. Parents of hashed children are recorded when parent is not nil.
+func merkleHash[T any](node *Node[T], data func(T) []byte, opts HashOptions, cache map[*Node[T]][]byte, parent map[*Node[T]]*Node[T]) []byte {
+	if sum, ok := cache[node]; ok {
+		return sum
+	}
+
+	children := make([][]byte, len(node.Children))
+	for i, child := range node.Children {
+		children[i] = merkleHash(child, data, opts, cache, parent)
+		if parent != nil {
+			parent[child] = node
+		}
+	}
+	if opts.Unordered {
+		slices.SortFunc(children, bytes.Compare)
+	}
+
+	newHash := opts.New
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	h := newHash()
+
+	// length prefixes keep different splits of the same bytes apart
+	buf := []byte{}
+	if opts.IncludeId {
+		buf = binary.AppendUvarint(buf, uint64(len(node.Id)))
+		buf = append(buf, node.Id...)
+	}
+	payload := data(node.Data)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+	buf = binary.AppendUvarint(buf, uint64(len(children)))
+	h.Write(buf)
+	for _, sum := range children {
+		h.Write(sum)
+	}
+
+	sum := h.Sum(nil)
+	cache[node] = sum
+	return sum
+}
@@ -0,0 +1,304 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// Kind of a tree mutation event.
+type EventKind int
+
+const (
+	NodeAdded         EventKind = iota + 1 // Node was added under NewParent at Index
+	NodeRemoved                            // Node was removed from OldParent at OldIndex
+	NodeMoved                              // Node was moved from OldParent/OldIndex to NewParent/Index
+	DataChanged                            // Node data was replaced, previous data is in OldData
+	ChildrenReordered                      // Children of Node were reordered, previous order is in OldChildren
+)
+
+// Returns event kind name.
+func (k EventKind) String() string {
+	switch k {
+	case NodeAdded:
+		return "added"
+	case NodeRemoved:
+		return "removed"
+	case NodeMoved:
+		return "moved"
+	case DataChanged:
+		return "data changed"
+	case ChildrenReordered:
+		return "reordered"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Describes a tree mutation.
+// Fields that don't apply to the event kind are left empty.
+type Event[T any] struct {
+	Kind      EventKind
+	Node      *Node[T]
+	OldParent *Node[T]
+	NewParent *Node[T]
+	OldIndex  int
+	Index     int
+	OldData   T
+
+	OldChildren []*Node[T]
+}
+
+// Subscription to mutation events of a subtree, returned by Subscribe() and SubscribeChan().
+type Subscription[T any] struct {
+	scope *Node[T]
+	fn    func(Event[T])
+	ch    chan Event[T]
+	hub   *hub[T]
+
+	done   chan struct{} // closed by Cancel() to release a blocked send
+	cancel sync.Once
+	mu     sync.Mutex
+	closed bool
+}
+
+// Returns the events channel, nil for synchronous subscriptions.
+func (s *Subscription[T]) C() <-chan Event[T] {
+	return s.ch
+}
+
+// Stops delivering events, and closes the events channel if any.
+func (s *Subscription[T]) Cancel() {
+	s.hub.unsubscribe(s)
+	s.cancel.Do(func() { close(s.done) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed && s.ch != nil {
+		close(s.ch)
+	}
+	s.closed = true
+}
+
+// Delivers event to subscriber.
+// Channel sends hold the lock so Cancel() never closes the channel during a send,
+// a blocked send gives up once Cancel() closes done.
+// Callbacks run without the lock so they can cancel their own subscription.
+func (s *Subscription[T]) deliver(e Event[T]) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if s.ch != nil {
+		select {
+		case s.ch <- e:
+		case <-s.done:
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.fn(e)
+}
+
+// Subscribes fn to mutation events inside the subtree of object node, including the node itself.
+// Events are delivered synchronously, fn runs before the mutating function returns and must not mutate the tree.
+//
+// Only mutations made through package functions are reported, e.g. AddNode(), Delete(), Move(), SetData() or Apply().
+// Assigning Data or Children directly is not observed.
+func (n *Node[T]) Subscribe(fn func(Event[T])) *Subscription[T] {
+	return n.subscribe(&Subscription[T]{scope: n, fn: fn, done: make(chan struct{})})
+}
+
+// Subscribes to mutation events inside the subtree of object node, delivered through a buffered channel of size.
+// When the buffer is full, mutating functions block until the event is received, so keep draining the channel.
+func (n *Node[T]) SubscribeChan(size int) *Subscription[T] {
+	return n.subscribe(&Subscription[T]{scope: n, ch: make(chan Event[T], size), done: make(chan struct{})})
+}
+
+// Attaches the subtree to a hub and registers subscription.
+func (n *Node[T]) subscribe(s *Subscription[T]) *Subscription[T] {
+	h := n.hub
+	if h == nil {
+		h = &hub[T]{parent: make(map[*Node[T]]*Node[T])}
+	}
+	h.attach(n)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s.hub = h
+	h.subs = append(h.subs, s)
+	return s
+}
+
+// Dispatches events to subscriptions of a tree, and tracks parents to match subscription scopes.
+type hub[T any] struct {
+	mu     sync.Mutex
+	subs   []*Subscription[T]
+	parent map[*Node[T]]*Node[T]
+}
+
+// Attaches node and its subtree to the hub, merging subscriptions of other hubs found inside.
+func (h *hub[T]) attach(node *Node[T]) {
+	if node.hub != nil && node.hub != h {
+		other := node.hub
+		other.mu.Lock()
+		subs := other.subs
+		for child, parent := range other.parent {
+			h.parent[child] = parent
+		}
+		other.mu.Unlock()
+
+		h.mu.Lock()
+		for _, s := range subs {
+			s.hub = h
+			h.subs = append(h.subs, s)
+		}
+		h.mu.Unlock()
+	}
+
+	node.hub = h
+	for _, child := range node.Children {
+		h.setParent(child, node)
+		h.attach(child)
+	}
+}
+
+// Removes subscription.
+func (h *hub[T]) unsubscribe(s *Subscription[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, sub := range h.subs {
+		if sub == s {
+			h.subs = append(h.subs[:i:i], h.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Checks if node is inside the subtree of scope.
+func (h *hub[T]) within(node, scope *Node[T]) bool {
+	for node != nil {
+		if node == scope {
+			return true
+		}
+		node = h.parent[node]
+	}
+	return false
+}
+
+// Returns subscriptions whose scope contains any of the nodes, appended to matched without repetition.
+func (h *hub[T]) match(matched []*Subscription[T], nodes ...*Node[T]) []*Subscription[T] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.subs {
+		if slices.Contains(matched, s) {
+			continue
+		}
+		for _, node := range nodes {
+			if h.within(node, s.scope) {
+				matched = append(matched, s)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// Records parent of node, or removes it when parent is nil.
+func (h *hub[T]) setParent(node, parent *Node[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if parent == nil {
+		delete(h.parent, node)
+		return
+	}
+	h.parent[node] = parent
+}
+
+// Forgets a removed subtree so it can be freed.
+// The subtree stays attached while a subscription is scoped inside it, to keep reporting its mutations.
+func (h *hub[T]) detach(node *Node[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.parent, node)
+	for _, s := range h.subs {
+		if h.within(s.scope, node) {
+			return
+		}
+	}
+
+	walkPreOrder(node, func(n, _ *Node[T], _ int) {
+		delete(h.parent, n)
+		n.hub = nil
+	})
+}
+
+// Delivers event to subscriptions.
+func deliver[T any](subs []*Subscription[T], e Event[T]) {
+	for _, s := range subs {
+		s.deliver(e)
+	}
+}
+
+// Reports node added under parent at index.
+func notifyAdded[T any](parent, node *Node[T], index int) {
+	h := parent.hub
+	if h == nil {
+		return
+	}
+
+	h.attach(node)
+	h.setParent(node, parent)
+	deliver(h.match(nil, parent), Event[T]{Kind: NodeAdded, Node: node, NewParent: parent, Index: index})
+}
+
+// Reports node removed from parent at index.
+// Subscriptions are matched at the old position, including those scoped to the removed node.
+func notifyRemoved[T any](parent, node *Node[T], index int) {
+	h := parent.hub
+	if h == nil {
+		return
+	}
+
+	subs := h.match(nil, parent, node)
+	h.detach(node)
+	deliver(subs, Event[T]{Kind: NodeRemoved, Node: node, OldParent: parent, OldIndex: index})
+}
+
+// Reports node moved between parents.
+// Subscriptions are matched at both the old and the new position.
+func notifyMoved[T any](oldParent *Node[T], oldIndex int, newParent, node *Node[T], index int) {
+	var subs []*Subscription[T]
+	if h := oldParent.hub; h != nil {
+		subs = h.match(subs, oldParent, node)
+		h.setParent(node, nil)
+	}
+	if h := newParent.hub; h != nil {
+		h.attach(node)
+		h.setParent(node, newParent)
+		subs = h.match(subs, newParent)
+	}
+
+	deliver(subs, Event[T]{Kind: NodeMoved, Node: node, OldParent: oldParent, NewParent: newParent, OldIndex: oldIndex, Index: index})
+}
+
+// Reports node data replaced.
+func notifyData[T any](node *Node[T], old T) {
+	if h := node.hub; h != nil {
+		deliver(h.match(nil, node), Event[T]{Kind: DataChanged, Node: node, OldData: old})
+	}
+}
+
+// Reports children of node reordered.
+func notifyReordered[T any](node *Node[T], old []*Node[T]) {
+	if h := node.hub; h != nil {
+		deliver(h.match(nil, node), Event[T]{Kind: ChildrenReordered, Node: node, OldChildren: old})
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Apply applies operations to the tree in order, usually the output of Diff().
+// Nodes are addressed by Id, or by Id path when the reference starts with a slash, e.g. "/0/2/4" where 0 is the root Id.
+// Apply is atomic, if any operation fails all previous operations are reverted and the tree is left untouched.
+func Apply[T any](root *Node[T], ops []Op[T]) error {
+	_, err := applyOps(root, ops)
+	return err
+}
+
+// Applies operations and returns a function reverting them all.
+// On failure, applied operations are already reverted.
+func applyOps[T any](root *Node[T], ops []Op[T]) (func(), error) {
+	undos := make([]func(), 0, len(ops))
+	revert := func() {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i]()
+		}
+	}
+
+	for i, op := range ops {
+		undo, err := applyOp(root, op)
+		if err != nil {
+			revert()
+			return nil, fmt.Errorf("op %d (%v): %w", i, op.Kind, err)
+		}
+		undos = append(undos, undo)
+	}
+
+	return revert, nil
+}
+
+// Applies a single operation and returns a function reverting it.
+func applyOp[T any](root *Node[T], op Op[T]) (func(), error) {
+	switch op.Kind {
+	case OpInsert:
+		parent := resolveRef(root, op.Parent)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: parent %q", ErrNodeNotFound, op.Parent)
+		}
+		if root.FindId(op.Id) != nil {
+			return nil, fmt.Errorf("node %q already exists", op.Id)
+		}
+		node := &Node[T]{Id: op.Id, Data: op.New}
+		insertChild(parent, node, op.Index)
+		return func() { removeChild(parent, node) }, nil
+
+	case OpDelete:
+		node, parent, err := resolveChild(root, op.Id)
+		if err != nil {
+			return nil, err
+		}
+		index := removeChild(parent, node)
+		return func() { insertChild(parent, node, index) }, nil
+
+	case OpUpdate:
+		node := resolveRef(root, op.Id)
+		if node == nil {
+			return nil, fmt.Errorf("%w: %q", ErrNodeNotFound, op.Id)
+		}
+		old := node.Data
+		node.SetData(op.New)
+		return func() { node.SetData(old) }, nil
+
+	case OpMove:
+		node, parent, err := resolveChild(root, op.Id)
+		if err != nil {
+			return nil, err
+		}
+		target := resolveRef(root, op.Parent)
+		if target == nil {
+			return nil, fmt.Errorf("%w: parent %q", ErrNodeNotFound, op.Parent)
+		}
+		if rootToNode(node, target) != nil {
+			return nil, fmt.Errorf("cannot move %q into its own subtree", op.Id)
+		}
+		index := slices.Index(parent.Children, node)
+		moveChild(parent, node, target, op.Index)
+		return func() { moveChild(target, node, parent, index) }, nil
+
+	case OpReorder:
+		parent := resolveRef(root, op.Parent)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: %q", ErrNodeNotFound, op.Parent)
+		}
+		if parent.order != nil {
+			return nil, fmt.Errorf("cannot reorder children of kept sorted node %q", op.Parent)
+		}
+		children, err := reorderChildren(parent.Children, op.Order)
+		if err != nil {
+			return nil, err
+		}
+		old := parent.Children
+		reorderChild(parent, children)
+		return func() { reorderChild(parent, old) }, nil
+	}
+
+	return nil, fmt.Errorf("unknown operation kind %d", int(op.Kind))
+}
+
+// Resolves a node reference, either an Id or an Id path starting with a slash.
+func resolveRef[T any](root *Node[T], ref string) *Node[T] {
+	if strings.HasPrefix(ref, "/") {
+		return resolveIdPath(root, strings.Split(ref[1:], "/"))
+	}
+	return root.FindId(ref)
+}
+
+// Resolves a non root node reference and its parent.
+func resolveChild[T any](root *Node[T], ref string) (*Node[T], *Node[T], error) {
+	node := resolveRef(root, ref)
+	if node == nil {
+		return nil, nil, fmt.Errorf("%w: %q", ErrNodeNotFound, ref)
+	}
+	if node == root {
+		return nil, nil, errors.New("cannot modify root node position")
+	}
+	parent, _ := parentOf(root, node)
+	return node, parent, nil
+}
+
+// Returns children ordered by Ids, order must list every child exactly once.
+func reorderChildren[T any](children []*Node[T], order []string) ([]*Node[T], error) {
+	if len(order) != len(children) {
+		return nil, fmt.Errorf("order lists %d children, node has %d", len(order), len(children))
+	}
+
+	byId := make(map[string]*Node[T], len(children))
+	for _, child := range children {
+		byId[child.Id] = child
+	}
+
+	result := make([]*Node[T], len(order))
+	for i, id := range order {
+		child, ok := byId[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: child %q", ErrNodeNotFound, id)
+		}
+		delete(byId, id)
+		result[i] = child
+	}
+
+	return result, nil
+}
+
+// Operation names used in JSON form, following RFC 6902 where an equivalent exists.
+var opNames = map[OpKind]string{
+	OpInsert:  "add",
+	OpDelete:  "remove",
+	OpUpdate:  "replace",
+	OpMove:    "move",
+	OpReorder: "reorder",
+}
+
+// JSON form of an operation.
+type jsonOp[T any] struct {
+	Op        string   `json:"op"`
+	Path      string   `json:"path,omitempty"`
+	Parent    string   `json:"parent,omitempty"`
+	Index     *int     `json:"index,omitempty"`
+	From      string   `json:"from,omitempty"`
+	FromIndex *int     `json:"fromIndex,omitempty"`
+	Value     *T       `json:"value,omitempty"`
+	OldValue  *T       `json:"oldValue,omitempty"`
+	Order     []string `json:"order,omitempty"`
+}
+
+// Encodes operation in RFC 6902 like form, e.g. {"op":"move","path":"4","parent":"1","index":0,"from":"2","fromIndex":1}.
+// Node Id is stored in "path", and previous parent in "from".
+func (op Op[T]) MarshalJSON() ([]byte, error) {
+	name, ok := opNames[op.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation kind %d", int(op.Kind))
+	}
+
+	j := jsonOp[T]{Op: name, Path: op.Id, Parent: op.Parent, From: op.FromParent, Order: op.Order}
+	switch op.Kind {
+	case OpInsert:
+		j.Index, j.Value = &op.Index, &op.New
+	case OpDelete:
+		j.FromIndex, j.OldValue = &op.FromIndex, &op.Old
+	case OpUpdate:
+		j.Value, j.OldValue = &op.New, &op.Old
+	case OpMove:
+		j.Index, j.FromIndex = &op.Index, &op.FromIndex
+	}
+
+	return json.Marshal(j)
+}
+
+// Decodes operation from its RFC 6902 like form.
+func (op *Op[T]) UnmarshalJSON(data []byte) error {
+	var j jsonOp[T]
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	*op = Op[T]{Id: j.Path, Parent: j.Parent, FromParent: j.From, Order: j.Order}
+	for kind, name := range opNames {
+		if name == j.Op {
+			op.Kind = kind
+		}
+	}
+	if op.Kind == 0 {
+		return fmt.Errorf("unknown operation %q", j.Op)
+	}
+
+	if j.Index != nil {
+		op.Index = *j.Index
+	}
+	if j.FromIndex != nil {
+		op.FromIndex = *j.FromIndex
+	}
+	if j.Value != nil {
+		op.New = *j.Value
+	}
+	if j.OldValue != nil {
+		op.Old = *j.OldValue
+	}
+
+	return nil
+}
+
+// Encodes operations into a JSON array, to be sent over wire instead of the whole tree.
+func EncodePatch[T any](ops []Op[T]) (string, error) {
+	j, err := json.Marshal(ops)
+	if err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
+
+// Decodes operations from a JSON array produced by EncodePatch().
+func DecodePatch[T any](jsonData string) ([]Op[T], error) {
+	var ops []Op[T]
+	if err := json.Unmarshal([]byte(jsonData), &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// Immutable tree node.
+// Every edit returns a new root, sharing untouched subtrees with the previous version,
+// so older versions stay valid and can be read concurrently without locks.
+// Edits copy only the nodes along the path to the edited node.
+//
+// Data is shared between versions as is, do not mutate data holding pointers, maps or slices.
+type PNode[T any] struct {
+	id       string
+	data     T
+	children []*PNode[T]
+}
+
+// Builds an immutable node. Children are copied, so the slice can be reused by the caller.
+func NewPNode[T any](id string, data T, children ...*PNode[T]) *PNode[T] {
+	return &PNode[T]{
+		id:       id,
+		data:     data,
+		children: slices.Clone(children),
+	}
+}
+
+// Builds an immutable copy of a tree.
+func Freeze[T any](root *Node[T]) *PNode[T] {
+	if root == nil {
+		return nil
+	}
+
+	p := &PNode[T]{id: root.Id, data: root.Data}
+	if len(root.Children) > 0 {
+		p.children = make([]*PNode[T], len(root.Children))
+		for i, child := range root.Children {
+			p.children[i] = Freeze(child)
+		}
+	}
+	return p
+}
+
+// Builds a mutable copy of the tree, object node is considered root node.
+func (p *PNode[T]) Thaw() *Node[T] {
+	node := &Node[T]{Id: p.id, Data: p.data}
+	if len(p.children) > 0 {
+		node.Children = make([]*Node[T], len(p.children))
+		for i, child := range p.children {
+			node.Children[i] = child.Thaw()
+		}
+	}
+	return node
+}
+
+// Returns node Id.
+func (p *PNode[T]) Id() string {
+	return p.id
+}
+
+// Returns node data.
+func (p *PNode[T]) Data() T {
+	return p.data
+}
+
+// Returns number of children.
+func (p *PNode[T]) Len() int {
+	return len(p.children)
+}
+
+// Returns child at index i.
+func (p *PNode[T]) Child(i int) *PNode[T] {
+	return p.children[i]
+}
+
+// Returns a copy of the children slice.
+func (p *PNode[T]) Children() []*PNode[T] {
+	return slices.Clone(p.children)
+}
+
+// find node by its Id and return it
+func (p *PNode[T]) FindId(id string) *PNode[T] {
+	if p.id == id {
+		return p
+	}
+
+	for _, child := range p.children {
+		if found := child.FindId(id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// List all nodes at certain depth, starting from object node which is considered as root node
+func (p *PNode[T]) Level(d int) []*PNode[T] {
+	level := []*PNode[T]{p}
+	for i := 0; i < d && len(level) > 0; i++ {
+		next := make([]*PNode[T], 0)
+		for _, node := range level {
+			next = append(next, node.children...)
+		}
+		level = next
+	}
+	return level
+}
+
+// Returns tree size, object node is considered root node.
+func (p *PNode[T]) Size() int {
+	size := 1
+	for _, child := range p.children {
+		size += child.Size()
+	}
+	return size
+}
+
+// Get all nodes from root node to a specific node.
+// Returns nil if target is not inside the tree.
+func (p *PNode[T]) PathToNode(target *PNode[T]) []*PNode[T] {
+	if p == target {
+		return []*PNode[T]{p}
+	}
+
+	for _, child := range p.children {
+		if path := child.PathToNode(target); path != nil {
+			return append([]*PNode[T]{p}, path...)
+		}
+	}
+	return nil
+}
+
+// Returns Lowest Common Ancestor for current Node Object.
+// Returns false if any of the nodes is not inside the tree.
+func (p *PNode[T]) LCA(a, b *PNode[T]) (*PNode[T], bool) {
+	pathA, pathB := p.PathToNode(a), p.PathToNode(b)
+	if pathA == nil || pathB == nil {
+		return nil, false
+	}
+
+	lca := p
+	for i := 0; i < len(pathA) && i < len(pathB) && pathA[i] == pathB[i]; i++ {
+		lca = pathA[i]
+	}
+	return lca, true
+}
+
+// Adds a child with id and data to the last node of path and returns the new root.
+// Path runs from object node to the parent, as returned by PathToNode().
+func (p *PNode[T]) AddNode(path []*PNode[T], id string, data T) (*PNode[T], error) {
+	idx, err := p.indexes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	child := &PNode[T]{id: id, data: data}
+	return p.update(idx, func(parent *PNode[T]) *PNode[T] {
+		return parent.withChildren(append(slices.Clone(parent.children), child))
+	}), nil
+}
+
+// Replaces data of the last node of path and returns the new root.
+func (p *PNode[T]) SetData(path []*PNode[T], data T) (*PNode[T], error) {
+	idx, err := p.indexes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.update(idx, func(node *PNode[T]) *PNode[T] {
+		return &PNode[T]{id: node.id, data: data, children: node.children}
+	}), nil
+}
+
+// Deletes the last node of path with its subtree and returns the new root.
+func (p *PNode[T]) Delete(path []*PNode[T]) (*PNode[T], error) {
+	idx, err := p.indexes(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) == 0 {
+		return nil, errors.New("cannot delete root node")
+	}
+
+	return p.remove(idx), nil
+}
+
+// Moves the last node of path under the last node of parentPath at index, and returns the new root.
+// Index out of range appends the node.
+func (p *PNode[T]) Move(path, parentPath []*PNode[T], index int) (*PNode[T], error) {
+	src, err := p.indexes(path)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := p.indexes(parentPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(src) == 0 {
+		return nil, errors.New("cannot move root node")
+	}
+	if len(dst) >= len(src) && slices.Equal(dst[:len(src)], src) {
+		return nil, errors.New("cannot move node into its own subtree")
+	}
+
+	node := path[len(path)-1]
+	root := p.remove(src)
+
+	// removing the node shifts following siblings, including a sibling on the way to the new parent
+	last := len(src) - 1
+	if len(dst) > last && slices.Equal(dst[:last], src[:last]) && dst[last] > src[last] {
+		dst = slices.Clone(dst)
+		dst[last]--
+	}
+
+	return root.update(dst, func(parent *PNode[T]) *PNode[T] {
+		if index < 0 || index > len(parent.children) {
+			index = len(parent.children)
+		}
+		return parent.withChildren(slices.Insert(slices.Clone(parent.children), index, node))
+	}), nil
+}
+
+// Converts a path of nodes into child indexes, validating every step.
+func (p *PNode[T]) indexes(path []*PNode[T]) ([]int, error) {
+	if len(path) == 0 || path[0] != p {
+		return nil, fmt.Errorf("%w: path must start at root", ErrNodeNotFound)
+	}
+
+	idx := make([]int, len(path)-1)
+	for i := 1; i < len(path); i++ {
+		idx[i-1] = slices.Index(path[i-1].children, path[i])
+		if idx[i-1] == -1 {
+			return nil, fmt.Errorf("%w: %q is not a child of %q", ErrNodeNotFound, path[i].id, path[i-1].id)
+		}
+	}
+	return idx, nil
+}
+
+// Copies nodes along idx and replaces the last one with the result of fn.
+func (p *PNode[T]) update(idx []int, fn func(*PNode[T]) *PNode[T]) *PNode[T] {
+	if len(idx) == 0 {
+		return fn(p)
+	}
+
+	children := slices.Clone(p.children)
+	children[idx[0]] = children[idx[0]].update(idx[1:], fn)
+	return p.withChildren(children)
+}
+
+// Copies nodes along idx and removes the last one.
+func (p *PNode[T]) remove(idx []int) *PNode[T] {
+	last := idx[len(idx)-1]
+	return p.update(idx[:len(idx)-1], func(parent *PNode[T]) *PNode[T] {
+		return parent.withChildren(slices.Delete(slices.Clone(parent.children), last, last+1))
+	})
+}
+
+// Returns a copy of node with other children.
+func (p *PNode[T]) withChildren(children []*PNode[T]) *PNode[T] {
+	return &PNode[T]{id: p.id, data: p.data, children: children}
+}
@@ -0,0 +1,781 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/slices"
+)
+
+// Compiled query over a tree, see CompileQuery() and CompileSelector().
+// A query is not bound to a data type, so it can be compiled once and run against many trees with Exec().
+type Query struct {
+	expr     string
+	absolute bool
+	steps    []queryStep
+}
+
+// Tree axis, the direction a step moves from its context node.
+type queryAxis int
+
+const (
+	axisChild queryAxis = iota
+	axisDescendant
+	axisDescendantOrSelf
+	axisParent
+	axisAncestor
+	axisAncestorOrSelf
+	axisSelf
+	axisFollowingSibling
+	axisPrecedingSibling
+	axisSibling
+	axisNextSibling // CSS "+" combinator
+)
+
+// Axis names accepted in "axis::" form.
+var queryAxes = map[string]queryAxis{
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"parent":             axisParent,
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"self":               axisSelf,
+	"following-sibling":  axisFollowingSibling,
+	"preceding-sibling":  axisPrecedingSibling,
+	"sibling":            axisSibling,
+}
+
+// Single location step, e.g. "child::*[leaf]".
+type queryStep struct {
+	axis     queryAxis
+	name     string // node Id to match, "*" matches any node
+	preds    []queryPred
+	document bool // passes the document above the root, set on the step inserted for "//"
+}
+
+// Kind of a step predicate.
+type predKind int
+
+const (
+	predPosition  predKind = iota // [2], position among step results of a context node
+	predLast                      // [last()]
+	predCompare                   // [depth>1], [@Name='Amr']
+	predExists                    // [@Name], data field is set
+	predLeaf                      // [leaf]
+	predRoot                      // [root]
+	predLastChild                 // CSS :last-child
+)
+
+// Step predicate filtering step results.
+type queryPred struct {
+	kind  predKind
+	pos   int
+	field string // depth, id, position(), children, index or a data field prefixed with @
+	op    string
+	value string
+}
+
+// Compiles an XPath like query.
+//
+// A query is a list of steps separated by "/", or by "//" to search all descendants. A leading "/" starts from above
+// the root, so "/0" selects the root if its Id is 0, while a relative query starts at the root, so "*" selects its children.
+// Each step is an optional axis, a node test and predicates, e.g. "ancestor::*[depth>0]":
+//
+//   - Axes: child (default), descendant, descendant-or-self, parent, ancestor, ancestor-or-self, self,
+//     following-sibling, preceding-sibling and sibling. "." is self::* and ".." is parent::*.
+//   - Node test: "*" matches any node, otherwise the node Id. Quote Ids with special characters, e.g. 'a b'.
+//   - Predicates: [n] and [last()] select by position, [leaf] and [root] by structure, and
+//     [operand op value] compares depth, id, position(), children (count), index (1 based among siblings)
+//     or a data field like @Name or @Address.City, using =, !=, <, <=, > or >=. [@Field] checks a field is set.
+func CompileQuery(expr string) (*Query, error) {
+	p := &queryParser{src: expr}
+	q, err := p.parseQuery()
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %w", expr, err)
+	}
+	return q, nil
+}
+
+// Compiles an XPath like query and panics if it is invalid.
+func MustCompileQuery(expr string) *Query {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// Compiles a CSS like selector, matching nodes anywhere in the tree including the root.
+//
+// Type selectors match node Ids, "*" matches any node and "#id" matches an Id as well. Attribute selectors match data
+// fields, e.g. [Name=Amr] or [Age>30], or check a field is set, e.g. [Boss]. Supported pseudo classes are :root, :leaf,
+// :empty, :first-child, :last-child and :nth-child(n). Combinators are " " (descendant), ">" (child),
+// "+" (next sibling) and "~" (following sibling).
+func CompileSelector(selector string) (*Query, error) {
+	p := &queryParser{src: selector}
+	q, err := p.parseSelector()
+	if err != nil {
+		return nil, fmt.Errorf("selector %q: %w", selector, err)
+	}
+	return q, nil
+}
+
+// Returns the source expression of the query.
+func (q *Query) String() string {
+	return q.expr
+}
+
+// Runs a compiled query against the tree starting at root, and returns matching nodes in pre-order without repetition.
+func Exec[T any](q *Query, root *Node[T]) []*Node[T] {
+	if root == nil {
+		return nil
+	}
+
+	ix := newQueryIndex(root)
+	context := []*Node[T]{root}
+	if q.absolute {
+		context = []*Node[T]{nil} // nil stands for the document above the root
+	}
+
+	for _, step := range q.steps {
+		next := make([]*Node[T], 0)
+		seen := make(map[*Node[T]]bool)
+		for _, c := range context {
+			for _, node := range ix.step(c, step) {
+				if !seen[node] {
+					seen[node] = true
+					next = append(next, node)
+				}
+			}
+		}
+		context = next
+	}
+
+	result := make([]*Node[T], 0, len(context))
+	for _, node := range context {
+		if node != nil {
+			result = append(result, node)
+		}
+	}
+	slices.SortFunc(result, func(a, b *Node[T]) int {
+		return ix.order[a] - ix.order[b]
+	})
+	return result
+}
+
+// Compiles and runs an XPath like query, object node is considered root node. See CompileQuery() for the syntax.
+func (n *Node[T]) Query(expr string) ([]*Node[T], error) {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return Exec(q, n), nil
+}
+
+// Compiles and runs a CSS like selector, object node is considered root node. See CompileSelector() for the syntax.
+func (n *Node[T]) Select(selector string) ([]*Node[T], error) {
+	q, err := CompileSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return Exec(q, n), nil
+}
+
+// Parents, depths and pre-order positions of a tree, built once per query run.
+type queryIndex[T any] struct {
+	root   *Node[T]
+	parent map[*Node[T]]*Node[T]
+	depth  map[*Node[T]]int
+	order  map[*Node[T]]int
+}
+
+// Indexes tree starting at root.
+func newQueryIndex[T any](root *Node[T]) *queryIndex[T] {
+	ix := &queryIndex[T]{
+		root:   root,
+		parent: make(map[*Node[T]]*Node[T]),
+		depth:  make(map[*Node[T]]int),
+		order:  make(map[*Node[T]]int),
+	}
+	walkPreOrder(root, func(node, parent *Node[T], _ int) {
+		ix.order[node] = len(ix.order)
+		if parent != nil {
+			ix.parent[node] = parent
+			ix.depth[node] = ix.depth[parent] + 1
+		}
+	})
+	return ix
+}
+
+// Returns children of node, the document's only child is the root.
+func (ix *queryIndex[T]) children(node *Node[T]) []*Node[T] {
+	if node == nil {
+		return []*Node[T]{ix.root}
+	}
+	return node.Children
+}
+
+// Returns siblings of node including itself, and its index among them.
+func (ix *queryIndex[T]) siblings(node *Node[T]) ([]*Node[T], int) {
+	parent, ok := ix.parent[node]
+	if !ok {
+		return []*Node[T]{node}, 0
+	}
+	return parent.Children, slices.Index(parent.Children, node)
+}
+
+// Evaluates a step from context node c.
+func (ix *queryIndex[T]) step(c *Node[T], step queryStep) []*Node[T] {
+	nodes := make([]*Node[T], 0)
+	for _, node := range ix.axis(c, step.axis) {
+		if node == nil {
+			if step.document {
+				nodes = append(nodes, node)
+			}
+		} else if step.name == "*" || node.Id == step.name {
+			nodes = append(nodes, node)
+		}
+	}
+
+	for _, pred := range step.preds {
+		filtered := make([]*Node[T], 0, len(nodes))
+		for i, node := range nodes {
+			if ix.test(node, pred, i+1, len(nodes)) {
+				filtered = append(filtered, node)
+			}
+		}
+		nodes = filtered
+	}
+	return nodes
+}
+
+// Returns nodes on axis from c, reverse axes list nearest nodes first.
+func (ix *queryIndex[T]) axis(c *Node[T], axis queryAxis) []*Node[T] {
+	switch axis {
+	case axisChild:
+		return ix.children(c)
+	case axisSelf:
+		return []*Node[T]{c}
+	case axisDescendant, axisDescendantOrSelf:
+		nodes := make([]*Node[T], 0)
+		if axis == axisDescendantOrSelf {
+			nodes = append(nodes, c)
+		}
+		for _, child := range ix.children(c) {
+			walkPreOrder(child, func(node, _ *Node[T], _ int) {
+				nodes = append(nodes, node)
+			})
+		}
+		return nodes
+	}
+
+	// remaining axes have nothing above the document
+	if c == nil {
+		return nil
+	}
+
+	switch axis {
+	case axisParent:
+		if parent, ok := ix.parent[c]; ok {
+			return []*Node[T]{parent}
+		}
+	case axisAncestor, axisAncestorOrSelf:
+		nodes := make([]*Node[T], 0)
+		if axis == axisAncestorOrSelf {
+			nodes = append(nodes, c)
+		}
+		for parent, ok := ix.parent[c]; ok; parent, ok = ix.parent[parent] {
+			nodes = append(nodes, parent)
+		}
+		return nodes
+	case axisFollowingSibling:
+		siblings, i := ix.siblings(c)
+		return siblings[i+1:]
+	case axisNextSibling:
+		if siblings, i := ix.siblings(c); i+1 < len(siblings) {
+			return siblings[i+1 : i+2]
+		}
+	case axisPrecedingSibling:
+		siblings, i := ix.siblings(c)
+		nodes := slices.Clone(siblings[:i])
+		slices.Reverse(nodes)
+		return nodes
+	case axisSibling:
+		siblings, i := ix.siblings(c)
+		return append(slices.Clone(siblings[:i]), siblings[i+1:]...)
+	}
+	return nil
+}
+
+// Tests predicate against node at position among size step results.
+func (ix *queryIndex[T]) test(node *Node[T], pred queryPred, position, size int) bool {
+	switch pred.kind {
+	case predPosition:
+		return position == pred.pos
+	case predLast:
+		return position == size
+	case predLeaf:
+		return len(node.Children) == 0
+	case predRoot:
+		return node == ix.root
+	case predLastChild:
+		siblings, i := ix.siblings(node)
+		return i == len(siblings)-1
+	case predExists:
+		v, ok := dataField(node.Data, pred.field[1:])
+		return ok && !v.IsZero()
+	}
+
+	var value string
+	switch pred.field {
+	case "depth":
+		value = strconv.Itoa(ix.depth[node])
+	case "id":
+		value = node.Id
+	case "position()":
+		value = strconv.Itoa(position)
+	case "children":
+		value = strconv.Itoa(len(node.Children))
+	case "index":
+		_, i := ix.siblings(node)
+		value = strconv.Itoa(i + 1)
+	default:
+		v, ok := dataField(node.Data, pred.field[1:])
+		if !ok {
+			return false
+		}
+		value = fmt.Sprint(v.Interface())
+	}
+	return compareValues(value, pred.op, pred.value)
+}
+
+// Returns a data field by dotted path, following pointers, struct fields and string keyed maps.
+func dataField(data any, path string) (reflect.Value, bool) {
+	v := reflect.ValueOf(data)
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+
+		switch v.Kind() {
+		case reflect.Struct:
+			v = v.FieldByName(name)
+			if !v.IsValid() || !v.CanInterface() {
+				return reflect.Value{}, false
+			}
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return reflect.Value{}, false
+			}
+			v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return reflect.Value{}, false
+			}
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	return v, true
+}
+
+// Compares values numerically if both are numbers, as strings otherwise.
+func compareValues(a, op, b string) bool {
+	cmp := strings.Compare(a, b)
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				cmp = -1
+			case x > y:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// Recursive descent parser for queries and selectors.
+type queryParser struct {
+	src string
+	pos int
+}
+
+// Parses an XPath like query.
+func (p *queryParser) parseQuery() (*Query, error) {
+	q := &Query{expr: p.src}
+	p.skipSpace()
+
+	if p.consume("//") {
+		q.absolute = true
+		q.steps = append(q.steps, queryStep{axis: axisDescendantOrSelf, name: "*", document: true})
+	} else if p.consume("/") {
+		q.absolute = true
+		if p.skipSpace(); p.done() {
+			// "/" alone selects the root
+			q.steps = append(q.steps, queryStep{axis: axisChild, name: "*"})
+			return q, nil
+		}
+	}
+
+	for {
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		q.steps = append(q.steps, step)
+
+		p.skipSpace()
+		switch {
+		case p.done():
+			return q, nil
+		case p.consume("//"):
+			q.steps = append(q.steps, queryStep{axis: axisDescendantOrSelf, name: "*", document: true})
+		case p.consume("/"):
+		default:
+			return nil, p.errorf("unexpected %q", p.src[p.pos:])
+		}
+	}
+}
+
+// Parses a location step.
+func (p *queryParser) parseStep() (queryStep, error) {
+	p.skipSpace()
+	if p.consume("..") {
+		return queryStep{axis: axisParent, name: "*"}, nil
+	}
+	if p.consume(".") {
+		return queryStep{axis: axisSelf, name: "*"}, nil
+	}
+
+	step := queryStep{axis: axisChild}
+	start := p.pos
+	if name := p.parseName(); name != "" && p.consume("::") {
+		axis, ok := queryAxes[name]
+		if !ok {
+			return step, p.errorf("unknown axis %q", name)
+		}
+		step.axis = axis
+	} else {
+		p.pos = start
+	}
+
+	name, err := p.parseNodeTest()
+	if err != nil {
+		return step, err
+	}
+	step.name = name
+
+	for p.skipSpace(); p.consume("["); p.skipSpace() {
+		pred, err := p.parsePredicate()
+		if err != nil {
+			return step, err
+		}
+		step.preds = append(step.preds, pred)
+		p.skipSpace()
+		if !p.consume("]") {
+			return step, p.errorf("expected ]")
+		}
+	}
+	return step, nil
+}
+
+// Parses "*", a name or a quoted name.
+func (p *queryParser) parseNodeTest() (string, error) {
+	p.skipSpace()
+	if p.consume("*") {
+		return "*", nil
+	}
+	if p.peekQuote() {
+		return p.parseQuoted()
+	}
+	if name := p.parseName(); name != "" {
+		return name, nil
+	}
+	return "", p.errorf("expected node test")
+}
+
+// Parses an XPath predicate body.
+func (p *queryParser) parsePredicate() (queryPred, error) {
+	p.skipSpace()
+	if p.consume("last()") {
+		return queryPred{kind: predLast}, nil
+	}
+
+	start := p.pos
+	for !p.done() && unicode.IsDigit(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos > start {
+		pos, _ := strconv.Atoi(p.src[start:p.pos])
+		return queryPred{kind: predPosition, pos: pos}, nil
+	}
+
+	var field string
+	switch {
+	case p.consume("position()"):
+		field = "position()"
+	case p.consume("@"):
+		field = "@" + p.parseName()
+		if field == "@" {
+			return queryPred{}, p.errorf("expected field name")
+		}
+	default:
+		field = p.parseName()
+	}
+
+	p.skipSpace()
+	op := p.parseOp()
+	if op == "" {
+		switch field {
+		case "leaf":
+			return queryPred{kind: predLeaf}, nil
+		case "root":
+			return queryPred{kind: predRoot}, nil
+		}
+		if strings.HasPrefix(field, "@") {
+			return queryPred{kind: predExists, field: field}, nil
+		}
+		return queryPred{}, p.errorf("invalid predicate %q", field)
+	}
+
+	switch field {
+	case "depth", "id", "position()", "children", "index":
+	default:
+		if !strings.HasPrefix(field, "@") {
+			return queryPred{}, p.errorf("unknown operand %q", field)
+		}
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return queryPred{}, err
+	}
+	return queryPred{kind: predCompare, field: field, op: op, value: value}, nil
+}
+
+// Parses a CSS like selector.
+func (p *queryParser) parseSelector() (*Query, error) {
+	q := &Query{expr: p.src, absolute: true}
+	axis := axisDescendantOrSelf
+
+	for {
+		p.skipSpace()
+		step, err := p.parseCompound(axis)
+		if err != nil {
+			return nil, err
+		}
+		q.steps = append(q.steps, step)
+
+		spaced := p.skipSpace()
+		if p.done() {
+			return q, nil
+		}
+		switch {
+		case p.consume(">"):
+			axis = axisChild
+		case p.consume("+"):
+			axis = axisNextSibling
+		case p.consume("~"):
+			axis = axisFollowingSibling
+		case spaced:
+			axis = axisDescendant
+		default:
+			return nil, p.errorf("unexpected %q", p.src[p.pos:])
+		}
+	}
+}
+
+// Parses a compound selector, e.g. "2#x[Age>30]:first-child".
+func (p *queryParser) parseCompound(axis queryAxis) (queryStep, error) {
+	step := queryStep{axis: axis, name: "*"}
+	matched := p.consume("*")
+	if !matched {
+		if name := p.parseName(); name != "" {
+			step.name = name
+			matched = true
+		}
+	}
+
+	for !p.done() {
+		switch {
+		case p.consume("#"):
+			id := p.parseName()
+			if id == "" {
+				return step, p.errorf("expected Id after #")
+			}
+			step.preds = append(step.preds, queryPred{kind: predCompare, field: "id", op: "=", value: id})
+
+		case p.consume("["):
+			field := p.parseName()
+			if field == "" {
+				return step, p.errorf("expected attribute name")
+			}
+			pred := queryPred{kind: predExists, field: "@" + field}
+			if op := p.parseOp(); op != "" {
+				value, err := p.parseValue()
+				if err != nil {
+					return step, err
+				}
+				pred = queryPred{kind: predCompare, field: "@" + field, op: op, value: value}
+			}
+			if !p.consume("]") {
+				return step, p.errorf("expected ]")
+			}
+			step.preds = append(step.preds, pred)
+
+		case p.consume(":"):
+			pred, err := p.parsePseudo()
+			if err != nil {
+				return step, err
+			}
+			step.preds = append(step.preds, pred)
+
+		default:
+			if !matched {
+				return step, p.errorf("expected selector")
+			}
+			return step, nil
+		}
+		matched = true
+	}
+	if !matched {
+		return step, p.errorf("expected selector")
+	}
+	return step, nil
+}
+
+// Parses a pseudo class after ":".
+func (p *queryParser) parsePseudo() (queryPred, error) {
+	name := p.parseName()
+	switch name {
+	case "root":
+		return queryPred{kind: predRoot}, nil
+	case "leaf", "empty":
+		return queryPred{kind: predLeaf}, nil
+	case "first-child":
+		return queryPred{kind: predCompare, field: "index", op: "=", value: "1"}, nil
+	case "last-child":
+		return queryPred{kind: predLastChild}, nil
+	case "nth-child":
+		if !p.consume("(") {
+			return queryPred{}, p.errorf("expected (")
+		}
+		value := p.parseName()
+		if _, err := strconv.Atoi(value); err != nil || !p.consume(")") {
+			return queryPred{}, p.errorf("expected nth-child(n)")
+		}
+		return queryPred{kind: predCompare, field: "index", op: "=", value: value}, nil
+	}
+	return queryPred{}, p.errorf("unknown pseudo class %q", name)
+}
+
+// Parses a comparison operator.
+func (p *queryParser) parseOp() string {
+	p.skipSpace()
+	for _, op := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		if p.consume(op) {
+			p.skipSpace()
+			return op
+		}
+	}
+	return ""
+}
+
+// Parses a quoted or bare value.
+func (p *queryParser) parseValue() (string, error) {
+	if p.peekQuote() {
+		return p.parseQuoted()
+	}
+	if value := p.parseName(); value != "" {
+		return value, nil
+	}
+	return "", p.errorf("expected value")
+}
+
+// Parses a name made of letters, digits and "_-.", stopping before "..".
+func (p *queryParser) parseName() string {
+	start := p.pos
+	for !p.done() {
+		r := rune(p.src[p.pos])
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' || r >= 0x80) {
+			break
+		}
+		if r == '.' && strings.HasPrefix(p.src[p.pos:], "..") {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// Parses a single or double quoted string.
+func (p *queryParser) parseQuoted() (string, error) {
+	quote := p.src[p.pos]
+	end := strings.IndexByte(p.src[p.pos+1:], quote)
+	if end == -1 {
+		return "", p.errorf("unterminated string")
+	}
+	value := p.src[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return value, nil
+}
+
+// Checks if next character is a quote.
+func (p *queryParser) peekQuote() bool {
+	return !p.done() && (p.src[p.pos] == '\'' || p.src[p.pos] == '"')
+}
+
+// Consumes s if it comes next.
+func (p *queryParser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// Skips white space and reports if any was skipped.
+func (p *queryParser) skipSpace() bool {
+	start := p.pos
+	for !p.done() && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// Checks if the whole source is consumed.
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+// Returns an error at the current position.
+func (p *queryParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
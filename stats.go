@@ -0,0 +1,95 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+// Tree metrics, see Node.Stats().
+type Stats[T any] struct {
+	Nodes       int        // count of all nodes, same as Size()
+	Leaves      int        // count of nodes without children
+	Height      int        // count of levels, same as Depth()
+	Widths      []int      // count of nodes at each level, Widths[0] is the root level
+	Branching   Branching  // distribution of children counts
+	DeepestPath []*Node[T] // path from root to the first deepest leaf
+	Widest      *Node[T]   // first node with the most children
+}
+
+// Branching factor distribution.
+// Min, Max and Mean count children of internal nodes only, they are zero for a single node tree.
+// Histogram maps a children count to the number of nodes having it, leaves are counted under 0.
+type Branching struct {
+	Min       int
+	Max       int
+	Mean      float64
+	Histogram map[int]int
+}
+
+// Returns tree metrics computed in one traversal, object node is considered root node.
+func (n *Node[T]) Stats() Stats[T] {
+	s := Stats[T]{Widest: n, Branching: Branching{Histogram: make(map[int]int)}}
+	internal, children := 0, 0
+
+	// iterative walk keeping the current path on the stack
+	type frame struct {
+		node *Node[T]
+		next int
+	}
+	stack := []frame{{node: n}}
+	pending := false // the deepest node so far is on the stack and its path is not saved yet
+	s.enter(n, 1, &pending)
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(top.node.Children) {
+			child := top.node.Children[top.next]
+			top.next++
+			stack = append(stack, frame{node: child})
+			s.enter(child, len(stack), &pending)
+			continue
+		}
+
+		node := top.node
+		count := len(node.Children)
+		s.Branching.Histogram[count]++
+		if count == 0 {
+			s.Leaves++
+		} else {
+			if internal == 0 || count < s.Branching.Min {
+				s.Branching.Min = count
+			}
+			internal++
+			children += count
+		}
+
+		if pending {
+			// leaving the deepest node, save its path once instead of on every new depth
+			s.DeepestPath = make([]*Node[T], len(stack))
+			for i, f := range stack {
+				s.DeepestPath[i] = f.node
+			}
+			pending = false
+		}
+		stack = stack[:len(stack)-1]
+	}
+
+	if internal > 0 {
+		s.Branching.Mean = float64(children) / float64(internal)
+	}
+	return s
+}
+
+// Counts node entered at level, levels start at 1 for the root.
+func (s *Stats[T]) enter(node *Node[T], level int, pending *bool) {
+	s.Nodes++
+	if level > s.Height {
+		s.Height = level
+		s.Widths = append(s.Widths, 0)
+		*pending = true
+	}
+	s.Widths[level-1]++
+
+	if len(node.Children) > s.Branching.Max {
+		s.Branching.Max = len(node.Children)
+		s.Widest = node
+	}
+}
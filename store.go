@@ -0,0 +1,368 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "wal.log"
+)
+
+// Options used when opening a store.
+type StoreOptions struct {
+	// Take a snapshot and compact the log after this many log records, zero disables automatic snapshots.
+	SnapshotEvery int
+
+	// Called when an automatic snapshot fails. The change is already in the log, so Apply() still succeeds,
+	// and the snapshot is retried after the next change.
+	OnSnapshotError func(error)
+}
+
+// File backed tree store, persisting every mutation to a write-ahead log before acknowledging it.
+// The tree is recovered on open by loading the latest snapshot and replaying the log.
+// A torn final log record, e.g. after a crash during write, is discarded.
+//
+// Nodes are addressed by Id, so Ids should be unique. A new store starts with an empty root with empty Id.
+// Store is safe for concurrent use.
+type Store[T any] struct {
+	mu      sync.RWMutex
+	dir     string
+	opts    StoreOptions
+	root    *Node[T]
+	log     logWriter
+	seq     uint64
+	pending int
+}
+
+// Write-ahead log file, implemented by *os.File opened for appending.
+type logWriter interface {
+	io.WriteCloser
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
+// Log record, a batch of operations applied atomically.
+type logRecord[T any] struct {
+	Seq uint64  `json:"seq"`
+	Ops []Op[T] `json:"ops"`
+}
+
+// Snapshot file content.
+type snapshot[T any] struct {
+	Seq  uint64           `json:"seq"`
+	Root *snapshotNode[T] `json:"root"`
+}
+
+// Node in snapshot file, unlike SerializeJSON() it keeps node Ids.
+type snapshotNode[T any] struct {
+	Id       string             `json:"id"`
+	Data     T                  `json:"data"`
+	Children []*snapshotNode[T] `json:"children,omitempty"`
+}
+
+// Opens the store in dir, creating it if needed, and recovers the tree.
+func OpenStore[T any](dir string, opts StoreOptions) (*Store[T], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store[T]{dir: dir, opts: opts, root: Tree[T]()}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.log = log
+	return s, nil
+}
+
+// Runs fn with read access to the tree. The callback must not modify the tree.
+func (s *Store[T]) View(fn func(root *Node[T])) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.root)
+}
+
+// Applies operations atomically and appends them to the log as a single record.
+// If the record cannot be written, operations are reverted and the error is returned.
+// Automatic snapshot errors are not returned, see StoreOptions.OnSnapshotError.
+func (s *Store[T]) Apply(ops ...Op[T]) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log == nil {
+		return errors.New("store is closed")
+	}
+
+	undo, err := applyOps(s.root, ops)
+	if err != nil {
+		return err
+	}
+	if err := s.append(logRecord[T]{Seq: s.seq + 1, Ops: ops}); err != nil {
+		undo()
+		return err
+	}
+	s.seq++
+	s.pending++
+
+	if s.opts.SnapshotEvery > 0 && s.pending >= s.opts.SnapshotEvery {
+		if err := s.snapshot(); err != nil && s.opts.OnSnapshotError != nil {
+			s.opts.OnSnapshotError(err)
+		}
+	}
+	return nil
+}
+
+// Adds a node with id and data under parent, see Apply().
+func (s *Store[T]) AddNode(parent, id string, data T) error {
+	return s.Apply(Op[T]{Kind: OpInsert, Id: id, Parent: parent, Index: -1, New: data})
+}
+
+// Deletes node with its subtree, see Apply().
+func (s *Store[T]) Delete(id string) error {
+	return s.Apply(Op[T]{Kind: OpDelete, Id: id})
+}
+
+// Moves node under parent at index, see Apply().
+func (s *Store[T]) Move(id, parent string, index int) error {
+	return s.Apply(Op[T]{Kind: OpMove, Id: id, Parent: parent, Index: index})
+}
+
+// Replaces node data, see Apply().
+func (s *Store[T]) SetData(id string, data T) error {
+	return s.Apply(Op[T]{Kind: OpUpdate, Id: id, New: data})
+}
+
+// Writes a snapshot of the tree and compacts the log.
+func (s *Store[T]) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log == nil {
+		return errors.New("store is closed")
+	}
+	return s.snapshot()
+}
+
+// Closes the log file. The store cannot be used afterwards.
+func (s *Store[T]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+// Writes snapshot atomically through a temporary file, then truncates the log.
+// If interrupted before truncating, replay skips records already in the snapshot.
+func (s *Store[T]) snapshot() error {
+	data, err := json.Marshal(snapshot[T]{Seq: s.seq, Root: toSnapshotNode(s.root)})
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	s.pending = 0
+	return s.log.Sync()
+}
+
+// Loads the latest snapshot if any.
+func (s *Store[T]) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot[T]
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("corrupted snapshot: %w", err)
+	}
+	if snap.Root != nil {
+		s.root = fromSnapshotNode(snap.Root)
+	}
+	s.seq = snap.Seq
+	return nil
+}
+
+// Replays log records newer than the snapshot.
+// A torn or corrupted final record is truncated, a corrupted record followed by valid ones is an error.
+func (s *Store[T]) replay() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		rec, decodeErr := decodeLogLine[T](line)
+		if decodeErr != nil {
+			if _, err := r.Peek(1); err != io.EOF {
+				return fmt.Errorf("corrupted log record at offset %d: %w", offset, decodeErr)
+			}
+			if err := f.Truncate(offset); err != nil {
+				return err
+			}
+			return f.Sync()
+		}
+		offset += int64(len(line))
+
+		if rec.Seq <= s.seq {
+			continue
+		}
+		if _, err := applyOps(s.root, rec.Ops); err != nil {
+			return fmt.Errorf("replaying log record %d: %w", rec.Seq, err)
+		}
+		s.seq = rec.Seq
+		s.pending++
+	}
+}
+
+// Appends a record to the log and syncs it to disk.
+// Each line holds the CRC32 checksum of the JSON record followed by the record.
+// If the record cannot be written, the log is truncated back so no partial record is left before later ones.
+func (s *Store[T]) append(rec logRecord[T]) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)
+	if _, err = s.log.Write([]byte(line)); err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		if terr := s.log.Truncate(info.Size()); terr != nil {
+			// later records would follow a corrupted one, stop writing
+			s.log.Close()
+			s.log = nil
+			return fmt.Errorf("%w, truncating log: %v", err, terr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Decodes and verifies a log line.
+func decodeLogLine[T any](line []byte) (logRecord[T], error) {
+	var rec logRecord[T]
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return rec, errors.New("incomplete record")
+	}
+
+	var sum uint32
+	checksum, data, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return rec, errors.New("missing checksum")
+	}
+	if _, err := fmt.Sscanf(string(checksum), "%08x", &sum); err != nil {
+		return rec, err
+	}
+	if sum != crc32.ChecksumIEEE(data) {
+		return rec, errors.New("checksum mismatch")
+	}
+
+	err := json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// Converts tree to snapshot nodes.
+func toSnapshotNode[T any](node *Node[T]) *snapshotNode[T] {
+	sn := &snapshotNode[T]{Id: node.Id, Data: node.Data}
+	for _, child := range node.Children {
+		sn.Children = append(sn.Children, toSnapshotNode(child))
+	}
+	return sn
+}
+
+// Converts snapshot nodes to a tree.
+func fromSnapshotNode[T any](sn *snapshotNode[T]) *Node[T] {
+	node := &Node[T]{Id: sn.Id, Data: sn.Data}
+	for _, child := range sn.Children {
+		node.Children = append(node.Children, fromSnapshotNode(child))
+	}
+	return node
+}
+
+// Writes file and syncs it to disk.
+func writeFileSync(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Syncs directory entries to disk, so renames are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
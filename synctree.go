@@ -0,0 +1,92 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import "sync"
+
+// Thread safe container for a tree shared across goroutines.
+// All access to the tree must go through View() or Update(),
+// node references must not be kept and used outside the callbacks.
+type SyncTree[T any] struct {
+	mu      sync.RWMutex
+	root    *Node[T]
+	journal *journal[T]
+}
+
+// Builds a thread safe container around root.
+// If root is nil, an empty tree is created.
+func NewSyncTree[T any](root *Node[T]) *SyncTree[T] {
+	if root == nil {
+		root = Tree[T]()
+	}
+	s := &SyncTree[T]{root: root, journal: &journal[T]{}}
+	setJournal(root, s.journal)
+	return s
+}
+
+// Runs a read only transaction, multiple readers can run concurrently.
+// The callback must not modify the tree.
+func (s *SyncTree[T]) View(fn func(root *Node[T])) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.root)
+}
+
+// Runs a read write transaction, exclusive of all other transactions.
+// If the callback returns an error, its changes are reverted and the error is returned.
+// Only changes made through package functions are reverted, e.g. AddNode(), Delete(), Move(), SetData() or Apply().
+// Assigning Data or Children directly is not reverted.
+func (s *SyncTree[T]) Update(fn func(root *Node[T]) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j := s.journal
+	j.open = true
+	defer j.close()
+
+	err := fn(s.root)
+	if err != nil {
+		j.revert()
+	}
+	return err
+}
+
+// Undo log of a SyncTree, written by mutating functions while a transaction is open.
+type journal[T any] struct {
+	open  bool
+	undos []func()
+}
+
+// Reverts recorded mutations, latest first, and closes the journal.
+func (j *journal[T]) revert() {
+	undos := j.undos
+	j.close()
+	for i := len(undos) - 1; i >= 0; i-- {
+		undos[i]()
+	}
+}
+
+// Stops recording and drops recorded mutations.
+func (j *journal[T]) close() {
+	j.open = false
+	j.undos = nil
+}
+
+// Records undo of a mutation made on node, if its tree has an open transaction.
+func journalize[T any](node *Node[T], undo func()) {
+	if j := node.journal; j != nil && j.open {
+		j.undos = append(j.undos, undo)
+	}
+}
+
+// Moves node and its subtree to journal j, so their mutations are recorded by the tree they belong to.
+func setJournal[T any](node *Node[T], j *journal[T]) {
+	if node.journal == j {
+		return
+	}
+	node.journal = j
+	for _, child := range node.Children {
+		setJournal(child, j)
+	}
+}
@@ -3,6 +3,8 @@
 // license that can be found in the LICENSE file.
 package gotrees
 
+import "strings"
+
 type Person struct {
 	Name string
 	Age  int
@@ -115,3 +117,36 @@ var (
 		},
 	}
 )
+
+// newOrgChart builds a fresh copy of the dataset tree, with unique Ids.
+// Use it in tests that mutate the tree or require unique Ids,
+// as the shared dataset above is modified by earlier tests.
+//
+//	0 Hany
+//	├── 2 Mezo
+//	│   ├── 5 Zaher
+//	│   ├── 4 Amr
+//	│   │   └── 6 Adham
+//	│   └── 44 Jebril
+//	└── 1 Hager
+//	    └── 3 Doaa
+func newOrgChart() *Node[Person] {
+	adham := &Node[Person]{Id: "6", Data: Person{Name: "Adham", Age: 12, Boss: "Amr"}}
+	zaher := &Node[Person]{Id: "5", Data: Person{Name: "Zaher", Age: 25, Boss: "Mezo"}}
+	amr := &Node[Person]{Id: "4", Data: Person{Name: "Amr", Age: 24, Boss: "Mezo"}, Children: []*Node[Person]{adham}}
+	jebril := &Node[Person]{Id: "44", Data: Person{Name: "Jebril", Age: 32, Boss: "Mezo"}}
+	doaa := &Node[Person]{Id: "3", Data: Person{Name: "Doaa", Age: 37, Boss: "Hager"}}
+	mezo := &Node[Person]{Id: "2", Data: Person{Name: "Mezo", Age: 40, Boss: "Hany"}, Children: []*Node[Person]{zaher, amr, jebril}}
+	hager := &Node[Person]{Id: "1", Data: Person{Name: "Hager", Age: 38, Boss: "Hany"}, Children: []*Node[Person]{doaa}}
+
+	return &Node[Person]{Id: "0", Data: Person{Name: "Hany", Age: 41}, Children: []*Node[Person]{mezo, hager}}
+}
+
+// Joins node Ids with commas.
+func nodeIds[T any](nodes []*Node[T]) string {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.Id
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import "golang.org/x/exp/slices"
+
+// Returns the longest path between any two nodes, ignoring edge direction, in PathN2N() order.
+// Object node is considered root node. When several paths are longest, the first found is returned.
+func (n *Node[T]) Diameter() []*Node[T] {
+	parents := make(map[*Node[T]]*Node[T])
+	walkPreOrder(n, func(node, parent *Node[T], _ int) {
+		if parent != nil {
+			parents[node] = parent
+		}
+	})
+
+	// the farthest node from any node is an end of a longest path
+	far, _ := farthest(n, parents)
+	end, prev := farthest(far, parents)
+
+	path := []*Node[T]{}
+	for node := end; node != nil; node = prev[node] {
+		path = append(path, node)
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// Returns the middle node of the diameter, or both middle nodes when the diameter has an even count of nodes.
+// Center nodes minimize the longest distance to any other node.
+func (n *Node[T]) Center() []*Node[T] {
+	path := n.Diameter()
+	mid := len(path) / 2
+	if len(path)%2 == 1 {
+		return path[mid : mid+1]
+	}
+	return path[mid-1 : mid+1]
+}
+
+// Returns the node, or the two adjacent nodes, whose removal leaves no part with more than half the nodes,
+// in Depth First Search (DFS) order. Object node is considered root node.
+func (n *Node[T]) Centroid() []*Node[T] {
+	sizes := make(map[*Node[T]]int)
+	walkPostOrder(n, func(node *Node[T]) {
+		sizes[node] = 1
+		for _, child := range node.Children {
+			sizes[node] += sizes[child]
+		}
+	})
+
+	total := sizes[n]
+	centroids := []*Node[T]{}
+	walkPreOrder(n, func(node, _ *Node[T], _ int) {
+		largest := total - sizes[node] // part above the node
+		for _, child := range node.Children {
+			largest = max(largest, sizes[child])
+		}
+		if 2*largest <= total {
+			centroids = append(centroids, node)
+		}
+	})
+	return centroids
+}
+
+// Reroots the tree at newRoot by flipping parent/child edges along the path from object node to newRoot,
+// and returns newRoot. Each old parent is appended to the children of its old child.
+// The tree is changed in place, so object node is no longer the root afterwards.
+// Each flip is reported to subscriptions as a removal followed by an addition.
+// Returns ErrNodeNotFound if newRoot is not inside the tree.
+func (n *Node[T]) Reroot(newRoot *Node[T]) (*Node[T], error) {
+	path := n.PathToNode(newRoot)
+	if path == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	for i := 1; i < len(path); i++ {
+		removeChild(path[i-1], path[i])
+		insertChild(path[i], path[i-1], -1)
+	}
+	return newRoot, nil
+}
+
+// Returns the farthest node from start ignoring edge direction, and the previous node of each reached node.
+func farthest[T any](start *Node[T], parents map[*Node[T]]*Node[T]) (*Node[T], map[*Node[T]]*Node[T]) {
+	prev := map[*Node[T]]*Node[T]{start: nil}
+	queue := []*Node[T]{start}
+	last := start
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		last = node
+
+		neighbours := node.Children
+		if parent, ok := parents[node]; ok {
+			neighbours = append([]*Node[T]{parent}, neighbours...)
+		}
+		for _, next := range neighbours {
+			if _, seen := prev[next]; !seen {
+				prev[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+	return last, prev
+}
@@ -6,6 +6,9 @@ package gotrees
 import (
 	"encoding/json"
 	"errors"
+	"strings"
+
+	"golang.org/x/exp/slices"
 )
 
 // Comparison function for building a tree.
@@ -18,6 +21,31 @@ type CompareFunc[T any] func(T, T) bool
 // You can encapsulate your logic for search inside it
 type FindFunc[T any] func(n *Node[T], C interface{}) bool
 
+// Comparison function for node data.
+// Returns true when both values are considered equal.
+type EqualFunc[T any] func(x, y T) bool
+
+// Comparison function for ordering children.
+// Returns a negative number when a comes before b, a positive number when a comes after b and zero when equal.
+type SortFunc[T any] func(a, b *Node[T]) int
+
+// Merge function for resolving inherited values.
+// First argument is the effective value of the parent, second argument is the child's own data.
+// Return the effective value of the child, e.g. keep parent fields the child leaves unset.
+type MergeFunc[T any] func(parent, child T) T
+
+// Sequence of nodes, yield returns false to stop early.
+// Has the same shape as iter.Seq[*Node[T]] and can be ranged over in newer Go versions.
+type NodeSeq[T any] func(yield func(*Node[T]) bool)
+
+var (
+	// Returned when a node passed to a receiver function is not part of the tree.
+	ErrNodeNotFound = errors.New("node not found")
+
+	// Returned when a function requires unique node Ids and the tree repeats an Id.
+	ErrDuplicateId = errors.New("duplicate node Id")
+)
+
 // The node structure. Each node is a container for any type of structs or primative types.
 // Node can be identified by `Id`, which helps in fast searching and doesn't require comparison function.
 // Id value is the responsibility of the consumer, you can use any identification method to identify nodes.
@@ -26,6 +54,10 @@ type Node[T any] struct {
 	Id       string
 	Data     T
 	Children []*Node[T]
+
+	order   SortFunc[T] // keeps children sorted on insert when set, see KeepSorted()
+	hub     *hub[T]     // delivers mutation events when the node is observed, see Subscribe()
+	journal *journal[T] // records undo of mutations while a SyncTree transaction is open
 }
 
 // Describes the full details of a Node.
@@ -38,19 +70,50 @@ type Details[T any] struct {
 }
 
 // Adds node to the current node and returns its memory reference.
+// If the node is kept sorted, the new node is inserted in its sorted position.
 func (n *Node[T]) AddNode(data T) *Node[T] {
 	node := Node[T]{Data: data}
-	n.Children = append(n.Children, &node)
+	insertChild(n, &node, -1)
 	return &node
 }
 
 // Adds node to the current node without data and returns its memory reference.
+// If the node is kept sorted, the new node is inserted in its sorted position.
 func (n *Node[T]) AddBlankNode() *Node[T] {
 	node := Node[T]{}
-	n.Children = append(n.Children, &node)
+	insertChild(n, &node, -1)
 	return &node
 }
 
+// Sorts children of the current node using comparison function.
+// Sorting is stable, equal children keep their insertion order.
+// If recursive is true, children of all descendants are sorted as well.
+func (n *Node[T]) SortChildren(cmp SortFunc[T], recursive bool) {
+	sortChildren(n, cmp)
+
+	if recursive {
+		for _, child := range n.Children {
+			child.SortChildren(cmp, true)
+		}
+	}
+}
+
+// Sorts the tree recursively and keeps it sorted, so AddNode() inserts children in their sorted position.
+// Object node is considered root node. Passing nil turns sorted mode off.
+// Comparison function is evaluated at insertion time, changing Data or Id afterwards doesn't move the node.
+func (n *Node[T]) KeepSorted(cmp SortFunc[T]) {
+	if cmp != nil {
+		sortChildren(n, cmp)
+	}
+	old := n.order
+	n.order = cmp
+	journalize(n, func() { n.order = old })
+
+	for _, child := range n.Children {
+		child.KeepSorted(cmp)
+	}
+}
+
 // find node by its Id and return it
 func (n *Node[T]) FindId(id string) *Node[T] {
 	if n.Id == id {
@@ -177,9 +240,37 @@ func (n *Node[T]) Slice() []T {
 }
 
 // Returns Lowest Common Ancestor for current Node Object
-func (n *Node[T]) LCA(p, q *Node[T]) *Node[T] {
-	node := findLowestCommonAncestor[T](n, p, q)
-	return node
+// Returns false if any of the nodes is not inside the tree.
+func (n *Node[T]) LCA(p, q *Node[T]) (*Node[T], bool) {
+	node, found := findLowestCommonAncestor[T](n, p, q)
+	return node, found == 2
+}
+
+// Returns Lowest Common Ancestor of all nodes, object node is considered root node.
+// Returns false if no nodes are given or any of them is not inside the tree.
+func (n *Node[T]) LCAOf(nodes ...*Node[T]) (*Node[T], bool) {
+	if len(nodes) == 0 {
+		return nil, false
+	}
+
+	// the ancestor is the last node of the common prefix of all paths
+	common := n.PathToNode(nodes[0])
+	for _, node := range nodes[1:] {
+		path := n.PathToNode(node)
+		if path == nil {
+			return nil, false
+		}
+		i := 0
+		for i < len(common) && i < len(path) && common[i] == path[i] {
+			i++
+		}
+		common = common[:i]
+	}
+
+	if len(common) == 0 {
+		return nil, false
+	}
+	return common[len(common)-1], true
 }
 
 // Serialize a tree into JSON format.
@@ -209,12 +300,13 @@ func (n *Node[T]) PathToNode(target *Node[T]) []*Node[T] {
 // Get path from node to node.
 // Object Node is considered as root node.
 // This function depends on LCA and PathToNode.
+// Returns empty path if any of the nodes is not inside the tree.
 func (n *Node[T]) PathN2N(p, q *Node[T]) []*Node[T] {
 	path := []*Node[T]{}
 
 	// get LCA for both nodes
-	lca := n.LCA(p, q)
-	if lca == nil {
+	lca, ok := n.LCA(p, q)
+	if !ok {
 		return path
 	}
 
@@ -240,6 +332,46 @@ func (n *Node[T]) PathN2N(p, q *Node[T]) []*Node[T] {
 	return path
 }
 
+// Get node by slash separated Id path, e.g. "0/2/4", where first segment is the Id of object node.
+// A leading slash is ignored. Returns nil if no node matches.
+// Unlike FindId(), repeated Ids are told apart by their location. Ids containing a slash can't be addressed.
+func (n *Node[T]) Get(idPath string) *Node[T] {
+	return resolveIdPath(n, strings.Split(strings.TrimPrefix(idPath, "/"), "/"))
+}
+
+// Returns slash separated Id path from object node to node, e.g. "0/2/4".
+// Returns empty string if node is not inside the tree.
+func (n *Node[T]) PathOf(node *Node[T]) string {
+	nodes := n.PathToNode(node)
+	ids := make([]string, len(nodes))
+	for i, p := range nodes {
+		ids[i] = p.Id
+	}
+	return strings.Join(ids, "/")
+}
+
+// Find all nodes whose Id path matches pattern, in Depth First Search (DFS) order.
+// Each segment is matched against an Id using path.Match syntax, e.g. "0/*/4",
+// and a "**" segment matches zero or more segments, e.g. "**/leaf". A trailing "**" matches all descendants.
+// Returns path.ErrBadPattern if pattern is malformed.
+func (n *Node[T]) Glob(pattern string) ([]*Node[T], error) {
+	segments, err := globSegments(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[*Node[T]]bool)
+	globMatch(n, segments, found)
+
+	matches := make([]*Node[T], 0, len(found))
+	walkPreOrder(n, func(node, _ *Node[T], _ int) {
+		if found[node] {
+			matches = append(matches, node)
+		}
+	})
+	return matches, nil
+}
+
 // Get paths from node to leaves. Object node is considered root
 // this function depends on PathToNode and Leaves()
 func (n *Node[T]) PathToLeaves() [][]*Node[T] {
@@ -255,6 +387,112 @@ func (n *Node[T]) PathToLeaves() [][]*Node[T] {
 	return paths
 }
 
+// Checks if a is a proper ancestor of b, object node is considered root node.
+// Returns ErrNodeNotFound if any of the nodes is not inside the tree.
+func (n *Node[T]) IsAncestor(a, b *Node[T]) (bool, error) {
+	if n.PathToNode(a) == nil {
+		return false, ErrNodeNotFound
+	}
+	path := n.PathToNode(b)
+	if path == nil {
+		return false, ErrNodeNotFound
+	}
+	return slices.Contains(path[:len(path)-1], a), nil
+}
+
+// Returns ancestors of node, nearest first and ending with object node, which is considered root node.
+// Returns ErrNodeNotFound if node is not inside the tree.
+func (n *Node[T]) Ancestors(node *Node[T]) (NodeSeq[T], error) {
+	path := n.PathToNode(node)
+	if path == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	return func(yield func(*Node[T]) bool) {
+		for i := len(path) - 2; i >= 0; i-- {
+			if !yield(path[i]) {
+				return
+			}
+		}
+	}, nil
+}
+
+// Returns all nodes below object node in Depth First Search (DFS) order.
+// The tree is walked lazily and must not be mutated while iterating.
+func (n *Node[T]) Descendants() NodeSeq[T] {
+	return func(yield func(*Node[T]) bool) {
+		stack := slices.Clone(n.Children)
+		slices.Reverse(stack)
+		for len(stack) > 0 {
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !yield(node) {
+				return
+			}
+			for i := len(node.Children) - 1; i >= 0; i-- {
+				stack = append(stack, node.Children[i])
+			}
+		}
+	}
+}
+
+// Returns siblings of node excluding itself, object node is considered root node and has no siblings.
+// Returns ErrNodeNotFound if node is not inside the tree.
+func (n *Node[T]) Siblings(node *Node[T]) ([]*Node[T], error) {
+	if n == node {
+		return []*Node[T]{}, nil
+	}
+
+	parent, index := parentOf(n, node)
+	if parent == nil {
+		return nil, ErrNodeNotFound
+	}
+	return append(slices.Clone(parent.Children[:index]), parent.Children[index+1:]...), nil
+}
+
+// Returns number of edges on the path between a and b, object node is considered root node.
+// Returns ErrNodeNotFound if any of the nodes is not inside the tree.
+func (n *Node[T]) Distance(a, b *Node[T]) (int, error) {
+	pa, pb := n.PathToNode(a), n.PathToNode(b)
+	if pa == nil || pb == nil {
+		return 0, ErrNodeNotFound
+	}
+
+	common := 0
+	for common < len(pa) && common < len(pb) && pa[common] == pb[common] {
+		common++
+	}
+	return len(pa) + len(pb) - 2*common, nil
+}
+
+// Collects the sequence into a slice.
+func (s NodeSeq[T]) Collect() []*Node[T] {
+	nodes := make([]*Node[T], 0)
+	s(func(node *Node[T]) bool {
+		nodes = append(nodes, node)
+		return true
+	})
+	return nodes
+}
+
+// Returns the effective value of target, by merging data along the path from object node to target.
+// Object node is considered root node, and its data is taken as is.
+// Returns ErrNodeNotFound if target is not inside the tree.
+func (n *Node[T]) EffectiveValue(target *Node[T], merge MergeFunc[T]) (T, error) {
+	path := n.PathToNode(target)
+	if len(path) == 0 {
+		var zero T
+		return zero, ErrNodeNotFound
+	}
+
+	value := path[0].Data
+	for _, node := range path[1:] {
+		value = merge(value, node.Data)
+	}
+
+	return value, nil
+}
+
 // Deletes a node from root. It finds the node and delete it regardless its location.
 // You don't need to provide any comparison function to delete.
 func (n *Node[T]) Delete(node *Node[T]) error {
@@ -262,19 +500,11 @@ func (n *Node[T]) Delete(node *Node[T]) error {
 		return errors.New("cannot delete root node")
 	}
 
-	det := findFullByMem[T](n, nil, 0, node)
-	if len(det.Parent.Children) == 0 {
-		return errors.New("children is empty")
-	}
-
-	newChildren := make([]*Node[T], 0)
-	for _, child := range det.Parent.Children {
-		if child != node {
-			newChildren = append(newChildren, child)
-		}
+	parent, _ := parentOf(n, node)
+	if parent == nil {
+		return ErrNodeNotFound
 	}
-	det.Parent.Children = make([]*Node[T], 0)
-	det.Parent.Children = append(det.Parent.Children, newChildren...)
+	removeChild(parent, node)
 
 	return nil
 }
@@ -282,25 +512,42 @@ func (n *Node[T]) Delete(node *Node[T]) error {
 // Trim leaves deletes all leaves and returns deleted objects.
 func (n *Node[T]) TrimLeaves() []*Node[T] {
 	leaves := n.Leaves()
-	trimmed := make([]*Node[T], len(leaves))
-	if len(leaves) == 0 {
-		return trimmed
+	trimmed := make([]*Node[T], 0, len(leaves))
+
+	for _, leaf := range leaves {
+		if parent, _ := parentOf(n, leaf); parent != nil {
+			removeChild(parent, leaf)
+			trimmed = append(trimmed, leaf)
+		}
 	}
 
-	for idx, leaf := range leaves {
+	return trimmed
+}
 
-		det := findFullByMem[T](n, nil, 0, leaf)
-		newChildren := make([]*Node[T], 0)
-		for _, child := range det.Parent.Children {
-			if child != leaf {
-				newChildren = append(newChildren, child)
-			}
-		}
-		det.Parent.Children = []*Node[T]{}
-		det.Parent.Children = append(det.Parent.Children, newChildren...)
+// Moves a node under another parent at index. It finds the node regardless its location.
+// Object node is considered root node. Index out of range appends the node.
+// Returns ErrNodeNotFound if any of the nodes is not inside the tree.
+func (n *Node[T]) Move(node, parent *Node[T], index int) error {
+	if n == node {
+		return errors.New("cannot move root node")
+	}
 
-		trimmed[idx] = leaf
+	oldParent, _ := parentOf(n, node)
+	if oldParent == nil || n.PathToNode(parent) == nil {
+		return ErrNodeNotFound
+	}
+	if node.PathToNode(parent) != nil {
+		return errors.New("cannot move node into its own subtree")
 	}
 
-	return trimmed
+	moveChild(oldParent, node, parent, index)
+	return nil
+}
+
+// Replaces node data.
+func (n *Node[T]) SetData(data T) {
+	old := n.Data
+	n.Data = data
+	journalize(n, func() { n.SetData(old) })
+	notifyData(n, old)
 }
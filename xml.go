@@ -0,0 +1,312 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+// Options used when encoding and decoding trees as nested XML, e.g. <node id="0"><node id="1"/></node>.
+// Zero value uses "node" elements, "id" attributes and "data" elements.
+type XMLOptions[T any] struct {
+	// Node element name, default "node".
+	Element string
+
+	// Id attribute name, default "id". Use "-" to leave Ids out.
+	IdAttr string
+
+	// Element holding node data encoded by encoding/xml, default "data". Ignored when Attrs is set.
+	DataElement string
+
+	// Encodes data as attributes of the node element instead of a data element.
+	Attrs func(data T) ([]xml.Attr, error)
+
+	// Decodes data from attributes of the node element, required when Attrs is set.
+	FromAttrs func(attrs []xml.Attr) (T, error)
+
+	// Indent nested elements, e.g. "  ". Empty writes everything on one line.
+	Indent string
+}
+
+// Returns options with defaults applied.
+func (o XMLOptions[T]) withDefaults() XMLOptions[T] {
+	if o.Element == "" {
+		o.Element = "node"
+	}
+	if o.IdAttr == "" {
+		o.IdAttr = "id"
+	}
+	if o.DataElement == "" {
+		o.DataElement = "data"
+	}
+	return o
+}
+
+// Writes tree to w as nested XML elements.
+func EncodeXML[T any](w io.Writer, root *Node[T], opts XMLOptions[T]) error {
+	if root == nil {
+		return errors.New("cannot encode nil tree")
+	}
+
+	opts = opts.withDefaults()
+	enc := xml.NewEncoder(w)
+	enc.Indent("", opts.Indent)
+	if err := encodeXMLNode(enc, root, opts); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// Reads a tree from nested XML elements.
+// The first node element found is the root, other elements are skipped.
+func DecodeXML[T any](r io.Reader, opts XMLOptions[T]) (*Node[T], error) {
+	opts = opts.withDefaults()
+	dec := xml.NewDecoder(r)
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, errors.New("no node element found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == opts.Element {
+			return decodeXMLNode(dec, start, opts)
+		}
+	}
+}
+
+// Recursive helper encoding node and its children.
+func encodeXMLNode[T any](enc *xml.Encoder, node *Node[T], opts XMLOptions[T]) error {
+	start := xml.StartElement{Name: xml.Name{Local: opts.Element}}
+	if opts.IdAttr != "-" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: opts.IdAttr}, Value: node.Id})
+	}
+	if opts.Attrs != nil {
+		attrs, err := opts.Attrs(node.Data)
+		if err != nil {
+			return err
+		}
+		start.Attr = append(start.Attr, attrs...)
+	}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if opts.Attrs == nil {
+		if err := enc.EncodeElement(node.Data, xml.StartElement{Name: xml.Name{Local: opts.DataElement}}); err != nil {
+			return err
+		}
+	}
+	for _, child := range node.Children {
+		if err := encodeXMLNode(enc, child, opts); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// Recursive helper decoding node element and its children, start is the already read node element.
+func decodeXMLNode[T any](dec *xml.Decoder, start xml.StartElement, opts XMLOptions[T]) (*Node[T], error) {
+	node := &Node[T]{}
+	attrs := make([]xml.Attr, 0, len(start.Attr))
+	for _, attr := range start.Attr {
+		if attr.Name.Local == opts.IdAttr {
+			node.Id = attr.Value
+		} else {
+			attrs = append(attrs, attr)
+		}
+	}
+	if opts.FromAttrs != nil {
+		data, err := opts.FromAttrs(attrs)
+		if err != nil {
+			return nil, err
+		}
+		node.Data = data
+	}
+
+	children, err := decodeXMLChildren(dec, opts, func(dec *xml.Decoder, el xml.StartElement) error {
+		if opts.Attrs != nil || el.Name.Local != opts.DataElement {
+			return dec.Skip()
+		}
+		return dec.DecodeElement(&node.Data, &el)
+	})
+	if err != nil {
+		return nil, err
+	}
+	node.Children = children
+	return node, nil
+}
+
+// Reads node elements until the end of the enclosing element.
+// Other elements are passed to other, which must consume them.
+func decodeXMLChildren[T any](dec *xml.Decoder, opts XMLOptions[T], other func(*xml.Decoder, xml.StartElement) error) ([]*Node[T], error) {
+	var children []*Node[T]
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == opts.Element {
+				child, err := decodeXMLNode(dec, t, opts)
+				if err != nil {
+					return nil, err
+				}
+				children = append(children, child)
+			} else if err := other(dec, t); err != nil {
+				return nil, err
+			}
+		case xml.EndElement:
+			return children, nil
+		}
+	}
+}
+
+// Outline of an OPML document.
+// Attributes other than the common ones are kept in Attrs.
+type Outline struct {
+	Text    string
+	Title   string
+	Type    string
+	XMLURL  string
+	HTMLURL string
+	Attrs   []xml.Attr
+}
+
+// Returns XML options for OPML outline elements.
+func OPMLOptions() XMLOptions[Outline] {
+	return XMLOptions[Outline]{
+		Element:   "outline",
+		IdAttr:    "-",
+		Attrs:     outlineAttrs,
+		FromAttrs: outlineFromAttrs,
+		Indent:    "  ",
+	}
+}
+
+// Writes an OPML 2.0 document.
+// Root node is the document itself, its Title is the head title and its children are the body outlines.
+func EncodeOPML(w io.Writer, root *Node[Outline]) error {
+	if root == nil {
+		return errors.New("cannot encode nil tree")
+	}
+
+	opts := OPMLOptions()
+	enc := xml.NewEncoder(w)
+	enc.Indent("", opts.Indent)
+
+	opml := xml.StartElement{Name: xml.Name{Local: "opml"}, Attr: []xml.Attr{{Name: xml.Name{Local: "version"}, Value: "2.0"}}}
+	head := xml.StartElement{Name: xml.Name{Local: "head"}}
+	body := xml.StartElement{Name: xml.Name{Local: "body"}}
+
+	if err := enc.EncodeToken(opml); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(head); err != nil {
+		return err
+	}
+	if err := enc.EncodeElement(root.Data.Title, xml.StartElement{Name: xml.Name{Local: "title"}}); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(head.End()); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(body); err != nil {
+		return err
+	}
+	for _, child := range root.Children {
+		if err := encodeXMLNode(enc, child, opts); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(body.End()); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(opml.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// Reads an OPML document.
+// Returns a root node holding the head title, with body outlines as its children.
+func DecodeOPML(r io.Reader) (*Node[Outline], error) {
+	opts := OPMLOptions()
+	dec := xml.NewDecoder(r)
+	root := &Node[Outline]{}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "title":
+			if err := dec.DecodeElement(&root.Data.Title, &start); err != nil {
+				return nil, err
+			}
+		case "body":
+			children, err := decodeXMLChildren(dec, opts, func(dec *xml.Decoder, _ xml.StartElement) error {
+				return dec.Skip()
+			})
+			if err != nil {
+				return nil, err
+			}
+			root.Children = children
+		}
+	}
+}
+
+// Encodes outline as OPML attributes.
+func outlineAttrs(o Outline) ([]xml.Attr, error) {
+	attrs := make([]xml.Attr, 0, 5+len(o.Attrs))
+	for _, a := range []struct{ name, value string }{
+		{"text", o.Text},
+		{"title", o.Title},
+		{"type", o.Type},
+		{"xmlUrl", o.XMLURL},
+		{"htmlUrl", o.HTMLURL},
+	} {
+		if a.value != "" || a.name == "text" {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: a.name}, Value: a.value})
+		}
+	}
+	return append(attrs, o.Attrs...), nil
+}
+
+// Decodes outline from OPML attributes.
+func outlineFromAttrs(attrs []xml.Attr) (Outline, error) {
+	var o Outline
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "text":
+			o.Text = attr.Value
+		case "title":
+			o.Title = attr.Value
+		case "type":
+			o.Type = attr.Value
+		case "xmlUrl":
+			o.XMLURL = attr.Value
+		case "htmlUrl":
+			o.HTMLURL = attr.Value
+		default:
+			o.Attrs = append(o.Attrs, attr)
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 Hany Mamdouh. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+package gotrees
+
+import (
+	"errors"
+
+	"golang.org/x/exp/slices"
+)
+
+// Functional zipper over a tree. Every move and edit returns a new zipper and never mutates the original tree,
+// edited nodes are copied while unchanged subtrees are shared. Call Root() to get the edited tree.
+// Copied nodes do not keep sorting or subscriptions of the originals.
+type Zipper[T any] struct {
+	focus  *Node[T]
+	dirty  bool // focus level differs from its parent children
+	crumbs []crumb[T]
+}
+
+// Way back to a parent, recorded when moving down.
+type crumb[T any] struct {
+	parent   *Node[T]
+	siblings []*Node[T] // focus level, never modified in place
+	index    int
+	dirty    bool // parent level differs from its own parent children
+}
+
+// Returns a zipper focused on the object node, which is considered root node.
+func (n *Node[T]) Zipper() *Zipper[T] {
+	return &Zipper[T]{focus: n}
+}
+
+// Returns the focused node, it must not be mutated.
+func (z *Zipper[T]) Node() *Node[T] {
+	return z.focus
+}
+
+// Returns depth of the focused node, root is at depth 0.
+func (z *Zipper[T]) Depth() int {
+	return len(z.crumbs)
+}
+
+// Returns index of the focused node among its siblings, or -1 at root.
+func (z *Zipper[T]) Index() int {
+	if len(z.crumbs) == 0 {
+		return -1
+	}
+	return z.crumbs[len(z.crumbs)-1].index
+}
+
+// Moves down to the child at index i.
+func (z *Zipper[T]) Down(i int) (*Zipper[T], bool) {
+	if i < 0 || i >= len(z.focus.Children) {
+		return z, false
+	}
+
+	c := crumb[T]{parent: z.focus, siblings: z.focus.Children, index: i, dirty: z.dirty}
+	return &Zipper[T]{focus: z.focus.Children[i], crumbs: append(z.crumbs[:len(z.crumbs):len(z.crumbs)], c)}, true
+}
+
+// Moves up to the parent, rebuilding it if anything below changed.
+func (z *Zipper[T]) Up() (*Zipper[T], bool) {
+	if len(z.crumbs) == 0 {
+		return z, false
+	}
+
+	c := z.crumbs[len(z.crumbs)-1]
+	up := &Zipper[T]{focus: c.parent, dirty: c.dirty, crumbs: z.crumbs[:len(z.crumbs)-1]}
+	if z.dirty {
+		up.focus = copyNode(c.parent, z.level(c))
+		up.dirty = true
+	}
+	return up, true
+}
+
+// Moves to the previous sibling.
+func (z *Zipper[T]) Left() (*Zipper[T], bool) {
+	return z.sibling(-1)
+}
+
+// Moves to the next sibling.
+func (z *Zipper[T]) Right() (*Zipper[T], bool) {
+	return z.sibling(1)
+}
+
+// Moves up to the root and returns the edited tree.
+// Returns the original root if nothing was edited.
+func (z *Zipper[T]) Root() *Node[T] {
+	for len(z.crumbs) > 0 {
+		z, _ = z.Up()
+	}
+	return z.focus
+}
+
+// Replaces the focused node with node.
+func (z *Zipper[T]) Replace(node *Node[T]) *Zipper[T] {
+	return &Zipper[T]{focus: node, dirty: true, crumbs: z.crumbs}
+}
+
+// Replaces data of the focused node.
+func (z *Zipper[T]) SetData(data T) *Zipper[T] {
+	node := copyNode(z.focus, z.focus.Children)
+	node.Data = data
+	return z.Replace(node)
+}
+
+// Appends node to the focused node children.
+func (z *Zipper[T]) AppendChild(node *Node[T]) *Zipper[T] {
+	children := append(z.focus.Children[:len(z.focus.Children):len(z.focus.Children)], node)
+	return z.Replace(copyNode(z.focus, children))
+}
+
+// Inserts node as the previous sibling of the focused node, focus stays on the same node.
+func (z *Zipper[T]) InsertLeft(node *Node[T]) (*Zipper[T], error) {
+	return z.insert(node, 0)
+}
+
+// Inserts node as the next sibling of the focused node, focus stays on the same node.
+func (z *Zipper[T]) InsertRight(node *Node[T]) (*Zipper[T], error) {
+	return z.insert(node, 1)
+}
+
+// Removes the focused node and moves to its next sibling,
+// or its previous sibling if it was the last child, or its parent if it was the only child.
+func (z *Zipper[T]) Remove() (*Zipper[T], error) {
+	if len(z.crumbs) == 0 {
+		return z, errors.New("cannot remove root node")
+	}
+
+	c := z.crumbs[len(z.crumbs)-1]
+	siblings := slices.Delete(slices.Clone(c.siblings), c.index, c.index+1)
+	if len(siblings) == 0 {
+		up := &Zipper[T]{focus: copyNode(c.parent, siblings), dirty: true, crumbs: z.crumbs[:len(z.crumbs)-1]}
+		return up, nil
+	}
+
+	if c.index == len(siblings) {
+		c.index--
+	}
+	c.siblings = siblings
+	return z.withCrumb(siblings[c.index], c), nil
+}
+
+// Returns focus level siblings with the focused node in place.
+func (z *Zipper[T]) level(c crumb[T]) []*Node[T] {
+	if !z.dirty {
+		return c.siblings
+	}
+	siblings := slices.Clone(c.siblings)
+	siblings[c.index] = z.focus
+	return siblings
+}
+
+// Moves to the sibling at offset from the focused node.
+func (z *Zipper[T]) sibling(offset int) (*Zipper[T], bool) {
+	if len(z.crumbs) == 0 {
+		return z, false
+	}
+
+	c := z.crumbs[len(z.crumbs)-1]
+	i := c.index + offset
+	if i < 0 || i >= len(c.siblings) {
+		return z, false
+	}
+
+	c.siblings = z.level(c)
+	c.index = i
+	next := z.withCrumb(c.siblings[i], c)
+	next.dirty = z.dirty
+	return next, true
+}
+
+// Inserts node next to the focused node, offset 0 is before and 1 is after.
+func (z *Zipper[T]) insert(node *Node[T], offset int) (*Zipper[T], error) {
+	if len(z.crumbs) == 0 {
+		return z, errors.New("cannot insert sibling of root node")
+	}
+
+	c := z.crumbs[len(z.crumbs)-1]
+	c.siblings = slices.Insert(slices.Clone(z.level(c)), c.index+offset, node)
+	c.index += 1 - offset
+	return z.withCrumb(z.focus, c), nil
+}
+
+// Returns a dirty zipper focused on node with the last crumb replaced.
+func (z *Zipper[T]) withCrumb(node *Node[T], c crumb[T]) *Zipper[T] {
+	n := len(z.crumbs) - 1
+	return &Zipper[T]{focus: node, dirty: true, crumbs: append(z.crumbs[:n:n], c)}
+}
+
+// Returns a shallow copy of node with children.
+func copyNode[T any](node *Node[T], children []*Node[T]) *Node[T] {
+	return &Node[T]{Id: node.Id, Data: node.Data, Children: children}
+}